@@ -0,0 +1,703 @@
+package inmemory
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy defines how often the append-only file is synced to the disk.
+type FsyncPolicy int
+
+// Fsync policies of the append-only file. The records are written to the
+// file by every command, the policy defines only when the operating system
+// is asked to flush them to the disk.
+const (
+	// FsyncEverySec syncs the file every second, so the crash of the
+	// machine loses up to one second of the writes.
+	FsyncEverySec FsyncPolicy = iota
+	// FsyncAlways syncs the file before the write command returns.
+	FsyncAlways
+	// FsyncNo leaves flushing of the file to the operating system.
+	FsyncNo
+)
+
+var fsyncPolicies = map[string]FsyncPolicy{
+	"everysec": FsyncEverySec,
+	"always":   FsyncAlways,
+	"no":       FsyncNo,
+}
+
+// String returns the name of the policy, e.g. "everysec".
+func (policy FsyncPolicy) String() string {
+	for name, p := range fsyncPolicies {
+		if p == policy {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// ParseFsyncPolicy returns the policy by its name: always, everysec or no.
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	policy, ok := fsyncPolicies[name]
+	if !ok {
+		return 0, errFsyncPolicy
+	}
+	return policy, nil
+}
+
+var (
+	errFsyncPolicy = errors.New("fsync policy should be always, everysec or no")
+	errAOFFormat   = errors.New("append-only file is corrupted")
+	errNoAOF       = errors.New("append-only file is disabled")
+	errRewriting   = errors.New("append-only file rewrite is already in progress")
+	errSaving      = errors.New("backup is already in progress")
+)
+
+// appendOnly is the log of the write commands applied to the data store.
+// Every record is the command with its arguments, encoded as the array of
+// bulk strings of Redis protocol:
+//
+//	*3\r\n$6\r\nREMOVE\r\n$3\r\nkey\r\n
+//
+// The ttl is logged as the absolute expiration time, so the replayed item
+// expires at the same time as the original one.
+//
+// The records are wrapped in the frames, if the file is encrypted or
+// compressed. The frame holds the flags byte (see encodePayload) and the
+// records encoded with them:
+//
+//	!<length of the flags and the payload>\r\n<flags><payload>\r\n
+//
+// Every appended record of the encrypted file is framed. The rewrite frames
+// the records of the dump in chunks, which are compressed as well.
+type appendOnly struct {
+	sync.Mutex
+	path   string
+	file   *os.File
+	policy FsyncPolicy
+	// flags of the frames of the rewrite and the keys to encrypt them
+	flags uint16
+	keys  *keyRing
+	// dirty is set when the records are written, but not synced yet
+	dirty bool
+	buf   []byte
+	// size is the current size of the file and baseSize is its size
+	// after the last rewrite or replay
+	size     int64
+	baseSize int64
+	// rewrite is set while the file is rewritten
+	rewrite *aofRewrite
+	// rewritten is set after the first rewrite finished with lastRewriteErr
+	rewritten      bool
+	lastRewriteErr error
+}
+
+// aofRewrite is the state of the running rewrite of the append-only file.
+// The shards are dumped to the new file one by one. The records of the keys
+// of the dumped shards are buffered, as the dump doesn't include them,
+// and they are written to the new file before it replaces the current one.
+type aofRewrite struct {
+	dumped map[*shard]bool
+	buf    []byte
+}
+
+// openAppendOnly opens the append-only file, creating it if it doesn't exist.
+// The records are encrypted if the key ring has the current key.
+func openAppendOnly(path string, policy FsyncPolicy, compress bool, keys *keyRing) (*appendOnly, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags uint16
+	if compress {
+		flags |= flagCompressed
+	}
+	if keys.key() != nil {
+		flags |= flagEncrypted
+	}
+	return &appendOnly{path: path, file: file, policy: policy, flags: flags, keys: keys}, nil
+}
+
+// append writes the record of the change of the key in the shard to the
+// file. Nothing is written if the append-only file is disabled or the
+// record is empty.
+func (aof *appendOnly) append(shard *shard, record ...string) error {
+	if aof == nil || len(record) == 0 {
+		return nil
+	}
+
+	aof.Lock()
+	defer aof.Unlock()
+
+	aof.buf = appendRecord(aof.buf[:0], record)
+	// the single record isn't worth compressing
+	if flags := aof.flags & flagEncrypted; flags != 0 {
+		framed, err := appendFrame(nil, aof.buf, flags, aof.keys.key())
+		if err != nil {
+			return err
+		}
+		aof.buf = framed
+	}
+	n, err := aof.file.Write(aof.buf)
+	aof.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if aof.rewrite != nil && aof.rewrite.dumped[shard] {
+		aof.rewrite.buf = append(aof.rewrite.buf, aof.buf...)
+	}
+
+	if aof.policy == FsyncAlways {
+		return aof.file.Sync()
+	}
+	aof.dirty = true
+	return nil
+}
+
+// sync flushes the written records to the disk.
+func (aof *appendOnly) sync() error {
+	aof.Lock()
+	defer aof.Unlock()
+
+	if !aof.dirty {
+		return nil
+	}
+	aof.dirty = false
+	return aof.file.Sync()
+}
+
+// close syncs and closes the file.
+func (aof *appendOnly) close() error {
+	if aof == nil {
+		return nil
+	}
+
+	err := aof.sync()
+	if closeErr := aof.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// appendRecord encodes the record to the buffer.
+func appendRecord(buf []byte, record []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(record)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range record {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// appendFrame encodes the frame of the records to the buffer.
+func appendFrame(buf, records []byte, flags uint16, key *EncryptionKey) ([]byte, error) {
+	// the flags are authenticated with the payload
+	payload, err := encodePayload(records, flags, key, []byte{byte(flags)})
+	if err != nil {
+		return nil, err
+	}
+
+	buf = append(buf, '!')
+	buf = strconv.AppendInt(buf, int64(len(payload)+1), 10)
+	buf = append(buf, '\r', '\n', byte(flags))
+	buf = append(buf, payload...)
+	return append(buf, '\r', '\n'), nil
+}
+
+// readFrame reads the next frame and returns its decoded records. It returns
+// io.EOF if there are no more frames and io.ErrUnexpectedEOF if the last
+// frame is incomplete.
+func readFrame(r *bufio.Reader, keys []*EncryptionKey) ([]byte, error) {
+	length, err := readLength(r, '!')
+	if err != nil {
+		return nil, err
+	}
+	if length < 1 || length > maxSnapshotSection {
+		return nil, errAOFFormat
+	}
+
+	frame := make([]byte, length+2)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if frame[length] != '\r' || frame[length+1] != '\n' {
+		return nil, errAOFFormat
+	}
+
+	flags := uint16(frame[0])
+	if flags == 0 || flags&^knownFlags != 0 {
+		return nil, errAOFFormat
+	}
+	return decodePayload(frame[1:length], flags, keys, frame[:1])
+}
+
+// readRecord reads the next record. It returns io.EOF if there are no more
+// records and io.ErrUnexpectedEOF if the last record is incomplete.
+func readRecord(r *bufio.Reader) ([]string, error) {
+	n, err := readLength(r, '*')
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]string, n)
+	for i := range record {
+		length, err := readLength(r, '$')
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if arg[length] != '\r' || arg[length+1] != '\n' {
+			return nil, errAOFFormat
+		}
+		record[i] = string(arg[:length])
+	}
+	return record, nil
+}
+
+// readLength reads the "<prefix><number>\r\n" line.
+func readLength(r *bufio.Reader, prefix byte) (int, error) {
+	line, err := r.ReadString('\n')
+	switch {
+	case err == io.EOF && line == "":
+		return 0, io.EOF
+	case err == io.EOF:
+		return 0, io.ErrUnexpectedEOF
+	case err != nil:
+		return 0, err
+	}
+
+	if len(line) < 3 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, errAOFFormat
+	}
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || n < 0 {
+		return 0, errAOFFormat
+	}
+	return n, nil
+}
+
+// replayAppendOnly applies the records of the append-only file to the data
+// store. The incomplete last record or frame, e.g. left by the crash in the
+// middle of the write, is cut from the file. Any other damage fails the
+// replay. The frames are decrypted with any key of the key ring, so the file
+// written with the previous key is replayed after the key rotation.
+func (dataStore *DataStore) replayAppendOnly(aof *appendOnly) error {
+
+	if _, err := aof.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	counter := &countingReader{r: aof.file}
+	reader := bufio.NewReader(counter)
+
+	// offset is the end of the last complete record
+	var offset int64
+	records := 0
+	for {
+		n, err := dataStore.replayNext(reader, aof.keys.keys())
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("Truncating incomplete record at offset %d of append-only file %s\n", offset, aof.file.Name())
+			if err := aof.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		offset = counter.n - int64(reader.Buffered())
+		records += n
+	}
+
+	// the growth of the file is measured from the replayed size
+	aof.size, aof.baseSize = offset, offset
+
+	log.Printf("Replayed %d records from append-only file %s\n", records, aof.file.Name())
+	return nil
+}
+
+// replayNext applies the next record or all the records of the next frame.
+// It returns the number of the applied records.
+func (dataStore *DataStore) replayNext(r *bufio.Reader, keys []*EncryptionKey) (int, error) {
+
+	if prefix, err := r.Peek(1); err != nil || prefix[0] != '!' {
+		record, err := readRecord(r)
+		if err != nil {
+			return 0, err
+		}
+		return 1, dataStore.apply(record)
+	}
+
+	payload, err := readFrame(r, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	frame := bufio.NewReader(bytes.NewReader(payload))
+	n := 0
+	for {
+		record, err := readRecord(frame)
+		switch {
+		case err == io.EOF:
+			return n, nil
+		// the complete frame can't hold the incomplete record
+		case err == io.ErrUnexpectedEOF:
+			return n, errAOFFormat
+		case err != nil:
+			return n, err
+		}
+
+		if err := dataStore.apply(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// countingReader counts the bytes read from the reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (counter *countingReader) Read(p []byte) (int, error) {
+	n, err := counter.r.Read(p)
+	counter.n += int64(n)
+	return n, err
+}
+
+// apply executes the logged record on the data store. The changes are not
+// logged again and not passed to the backing store.
+func (dataStore *DataStore) apply(record []string) error {
+
+	if len(record) < 2 {
+		return errAOFFormat
+	}
+
+	key := record[1]
+	shard := dataStore.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	item, ok := shard.get(key)
+
+	switch {
+	case record[0] == "SET" && len(record) == 4:
+		expire, err := strconv.ParseInt(record[3], 10, 64)
+		if err != nil {
+			return errAOFFormat
+		}
+		shard.set(key, &Item{Value: record[2], expire: expire})
+	case record[0] == "LPUSH" && len(record) >= 3:
+		values := append([]string(nil), record[2:]...)
+		if !ok {
+			shard.set(key, &Item{Value: values})
+			return nil
+		}
+		list, isList := item.Value.([]string)
+		if !isList {
+			return errAOFFormat
+		}
+		item.Value = append(list, values...)
+	case record[0] == "LSET" && len(record) == 4:
+		index, err := strconv.Atoi(record[2])
+		if err != nil || !ok {
+			return errAOFFormat
+		}
+		list, isList := item.Value.([]string)
+		if !isList || index < 0 || index >= len(list) {
+			return errAOFFormat
+		}
+		list[index] = record[3]
+	case record[0] == "HSET" && len(record) >= 4 && len(record)%2 == 0:
+		if !ok {
+			item = &Item{Value: map[string]string{}}
+			shard.set(key, item)
+		}
+		hash, isHash := item.Value.(map[string]string)
+		if !isHash {
+			return errAOFFormat
+		}
+		for i := 2; i < len(record); i += 2 {
+			hash[record[i]] = record[i+1]
+		}
+	case record[0] == "EXPIREAT" && len(record) == 3:
+		expire, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return errAOFFormat
+		}
+		// the ttl is changed only for the existing keys
+		if !ok {
+			return errAOFFormat
+		}
+		shard.expire(key, item, expire)
+	case record[0] == "REMOVE" && len(record) == 2:
+		if ok {
+			return shard.remove(key, Removed)
+		}
+	default:
+		return errAOFFormat
+	}
+	return nil
+}
+
+// RewriteAppendOnly replaces the append-only file with the minimal one
+// producing the current data. The write commands are not blocked while
+// the data is dumped to the new file, their records are added to it
+// before it atomically replaces the current one.
+func (dataStore *DataStore) RewriteAppendOnly() error {
+
+	if err := dataStore.aof.startRewrite(); err != nil {
+		return err
+	}
+	return dataStore.finishRewrite()
+}
+
+// BackgroundRewriteAppendOnly starts RewriteAppendOnly in background.
+// The result is logged and reported by INFO.
+func (dataStore *DataStore) BackgroundRewriteAppendOnly() error {
+
+	if err := dataStore.aof.startRewrite(); err != nil {
+		return err
+	}
+
+	dataStore.background.Add(1)
+	go func() {
+		defer dataStore.background.Done()
+		if err := dataStore.finishRewrite(); err != nil {
+			log.Println("Error rewriting append-only file", err)
+		}
+	}()
+	return nil
+}
+
+// startRewrite marks the rewrite as running.
+func (aof *appendOnly) startRewrite() error {
+	if aof == nil {
+		return errNoAOF
+	}
+
+	aof.Lock()
+	defer aof.Unlock()
+
+	if aof.rewrite != nil {
+		return errRewriting
+	}
+	aof.rewrite = &aofRewrite{dumped: make(map[*shard]bool)}
+	return nil
+}
+
+// finishRewrite runs the rewrite started by startRewrite.
+func (dataStore *DataStore) finishRewrite() error {
+
+	aof := dataStore.aof
+	err := dataStore.rewriteAppendOnly(aof)
+
+	aof.Lock()
+	aof.rewrite = nil
+	aof.rewritten = true
+	aof.lastRewriteErr = err
+	aof.Unlock()
+
+	if err == nil {
+		log.Println("Append-only file rewritten")
+	}
+	return err
+}
+
+func (dataStore *DataStore) rewriteAppendOnly(aof *appendOnly) error {
+
+	temp := aof.path + ".rewrite"
+	file, err := os.OpenFile(temp, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	// the new file is removed, unless it replaced the current one
+	replaced := false
+	defer func() {
+		if !replaced {
+			file.Close()
+			os.Remove(temp)
+		}
+	}()
+
+	var size int64
+	for _, shard := range dataStore.shards {
+		dump, err := aof.dump(shard)
+		if err != nil {
+			return err
+		}
+		n, err := file.Write(dump)
+		size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the writes are blocked until the file is replaced
+	aof.Lock()
+	defer aof.Unlock()
+
+	n, err := file.Write(aof.rewrite.buf)
+	size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, aof.path); err != nil {
+		return err
+	}
+
+	replaced = true
+	aof.file.Close()
+	aof.file = file
+	aof.size, aof.baseSize = size, size
+	aof.dirty = false
+	return nil
+}
+
+// dump encodes the records creating the items of the shard. The shard is
+// marked as dumped, so the later changes of its keys are buffered.
+// The records are framed in chunks if the file is compressed or encrypted.
+func (aof *appendOnly) dump(shard *shard) ([]byte, error) {
+	shard.RLock()
+	defer shard.RUnlock()
+
+	var buf, records []byte
+	var err error
+	for key, item := range shard.values {
+		records = appendItem(records, key, item)
+		if aof.flags != 0 && len(records) >= snapshotSectionSize {
+			if buf, err = appendFrame(buf, records, aof.flags, aof.keys.key()); err != nil {
+				return nil, err
+			}
+			records = records[:0]
+		}
+	}
+
+	if aof.flags == 0 {
+		buf = records
+	} else if len(records) > 0 {
+		if buf, err = appendFrame(buf, records, aof.flags, aof.keys.key()); err != nil {
+			return nil, err
+		}
+	}
+
+	aof.Lock()
+	aof.rewrite.dumped[shard] = true
+	aof.Unlock()
+
+	return buf, nil
+}
+
+// appendItem encodes the records creating the item to the buffer.
+func appendItem(buf []byte, key string, item *Item) []byte {
+	switch value := item.Value.(type) {
+	case string:
+		return appendRecord(buf, []string{"SET", key, value, strconv.FormatInt(item.expire, 10)})
+	case []string:
+		if len(value) == 0 {
+			return buf
+		}
+		buf = appendRecord(buf, append([]string{"LPUSH", key}, value...))
+	case map[string]string:
+		if len(value) == 0 {
+			return buf
+		}
+		record := []string{"HSET", key}
+		for hashKey, hashValue := range value {
+			record = append(record, hashKey, hashValue)
+		}
+		buf = appendRecord(buf, record)
+	default:
+		return buf
+	}
+
+	if item.expire != 0 {
+		buf = appendRecord(buf, []string{"EXPIREAT", key, strconv.FormatInt(item.expire, 10)})
+	}
+	return buf
+}
+
+// rewriteNeeded checks if the file grew enough since the last rewrite.
+func (aof *appendOnly) rewriteNeeded(percentage int, minSize int64) bool {
+	aof.Lock()
+	defer aof.Unlock()
+
+	return aof.rewrite == nil && aof.size >= minSize && aof.size > aof.baseSize &&
+		aof.size >= aof.baseSize+aof.baseSize*int64(percentage)/100
+}
+
+// rewrited is the worker rewriting the append-only file when it grows
+// by the configured percentage.
+func (dataStore *DataStore) rewrited() {
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-dataStore.done:
+			return
+		}
+
+		if !dataStore.aof.rewriteNeeded(dataStore.config.aofRewritePercent, dataStore.config.aofRewriteMinSize) {
+			continue
+		}
+		if err := dataStore.RewriteAppendOnly(); err != nil {
+			log.Println("Error rewriting append-only file", err)
+		}
+	}
+}
+
+// fsyncd is the worker syncing the append-only file every second.
+func (dataStore *DataStore) fsyncd() {
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-dataStore.done:
+			return
+		}
+
+		if err := dataStore.aof.sync(); err != nil {
+			log.Println("Error syncing append-only file", err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package inmemory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openAOFStore(t *testing.T, path string) *DataStore {
+	t.Helper()
+
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithAppendOnly(path, FsyncAlways))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataStore
+}
+
+func TestAppendOnlyReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.aof")
+
+	dataStore := openAOFStore(t, path)
+	dataStore.Set("string", "value with spaces\r\n", NoExpiration)
+	dataStore.Set("expiring", "value", time.Hour)
+	dataStore.Set("removed", "value", NoExpiration)
+	dataStore.Remove("removed")
+	dataStore.LPush("list", "a")
+	dataStore.LPush("list", "b")
+	dataStore.LSet("list", 0, "c")
+	dataStore.HSet("hash", "a", "1")
+	dataStore.HSet("hash", "b", "2")
+	dataStore.Expire("string", 10*time.Minute)
+	expire := dataStore.shard("string").values["string"].expire
+	if err := dataStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openAOFStore(t, path)
+	defer restored.Close()
+
+	if value, err := restored.Get("string"); value != "value with spaces\r\n" || err != nil {
+		t.Errorf("Expected restored string, got %q, %v", value, err)
+	}
+	if item := restored.shard("string").values["string"]; item.expire != expire {
+		t.Errorf("Expected expiration %d, got %d", expire, item.expire)
+	}
+	if _, err := restored.Get("removed"); err != ErrNoItem {
+		t.Errorf("Expected %v, got %v", ErrNoItem, err)
+	}
+	if list, _ := restored.List("list"); !reflect.DeepEqual(list, []string{"c", "b"}) {
+		t.Errorf("Expected list [c b], got %v", list)
+	}
+	if hash, _ := restored.Hash("hash"); !reflect.DeepEqual(hash, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("Expected restored hash, got %v", hash)
+	}
+	if size := restored.Size(); size != 4 {
+		t.Errorf("Expected 4 keys, got %d", size)
+	}
+}
+
+func TestAppendOnlyTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.aof")
+
+	dataStore := openAOFStore(t, path)
+	dataStore.Set("a", "1", NoExpiration)
+	dataStore.Close()
+
+	info, _ := os.Stat(path)
+	complete := info.Size()
+
+	// the crash in the middle of the record
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString("*4\r\n$3\r\nSET\r\n$1\r\nb\r\n$2\r\n2")
+	file.Close()
+
+	dataStore = openAOFStore(t, path)
+	if info, _ := os.Stat(path); info.Size() != complete {
+		t.Errorf("Expected incomplete record to be cut to %d bytes, got %d", complete, info.Size())
+	}
+	if value, _ := dataStore.Get("a"); value != "1" {
+		t.Errorf("Expected value 1, got %q", value)
+	}
+	if _, err := dataStore.Get("b"); err != ErrNoItem {
+		t.Errorf("Expected %v, got %v", ErrNoItem, err)
+	}
+
+	// the new records are appended after the last complete one
+	dataStore.Set("b", "2", NoExpiration)
+	dataStore.Close()
+
+	dataStore = openAOFStore(t, path)
+	defer dataStore.Close()
+	if value, _ := dataStore.Get("b"); value != "2" {
+		t.Errorf("Expected value 2, got %q", value)
+	}
+}
+
+func TestAppendOnlyCorrupted(t *testing.T) {
+	cases := map[string]string{
+		"garbage":         "garbage\r\n*2\r\n$6\r\nREMOVE\r\n$1\r\na\r\n",
+		"unknown command": "*2\r\n$4\r\nKILL\r\n$1\r\na\r\n",
+		"bad length":      "*2\r\n$2\r\nREMOVE\r\n$1\r\na\r\n",
+		"expire missing":  "*3\r\n$8\r\nEXPIREAT\r\n$1\r\na\r\n$10\r\n1700000000\r\n",
+	}
+	for name, content := range cases {
+		path := filepath.Join(t.TempDir(), "data.aof")
+		os.WriteFile(path, []byte(content), 0644)
+
+		if _, err := NewWithOptions(WithWorkers(NoWorkers), WithAppendOnly(path, FsyncNo)); err != errAOFFormat {
+			t.Errorf("%s: expected %v, got %v", name, errAOFFormat, err)
+		}
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for _, policy := range []FsyncPolicy{FsyncAlways, FsyncEverySec, FsyncNo} {
+		if parsed, err := ParseFsyncPolicy(policy.String()); parsed != policy || err != nil {
+			t.Errorf("Expected %v, got %v, %v", policy, parsed, err)
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err != errFsyncPolicy {
+		t.Errorf("Expected %v, got %v", errFsyncPolicy, err)
+	}
+}
+
+func TestAppendOnlyExpiredRemoval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.aof")
+
+	dataStore := openAOFStore(t, path)
+	dataStore.Set("key", "value", time.Second)
+	dataStore.shard("key").removeExpired(time.Now().Unix() + 10)
+	if err := dataStore.LPush("key", "a"); err != nil {
+		t.Fatal(err)
+	}
+	dataStore.Close()
+
+	// the expired string is removed before the list is created
+	restored := openAOFStore(t, path)
+	defer restored.Close()
+	if list, err := restored.List("key"); !reflect.DeepEqual(list, []string{"a"}) || err != nil {
+		t.Errorf("Expected list [a], got %v, %v", list, err)
+	}
+}
+
+func TestRewriteAppendOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.aof")
+
+	dataStore := openAOFStore(t, path)
+	for i := 0; i < 100; i++ {
+		dataStore.Set("counter", strconv.Itoa(i), NoExpiration)
+	}
+	dataStore.LPush("list", "a")
+	dataStore.LPush("list", "b")
+	dataStore.HSet("hash", "a", "1")
+	dataStore.HSet("hash", "b", "2")
+	dataStore.Expire("hash", time.Hour)
+	dataStore.Set("removed", "value", NoExpiration)
+	dataStore.Remove("removed")
+	expire := dataStore.shard("hash").values["hash"].expire
+
+	before, _ := os.Stat(path)
+	if err := dataStore.RewriteAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("Expected rewritten file to be smaller than %d bytes, got %d", before.Size(), after.Size())
+	}
+
+	info, _ := dataStore.Info("persistence")
+	fields := infoFields(info)
+	if fields["aof_enabled"] != "1" || fields["aof_base_size"] != strconv.FormatInt(after.Size(), 10) {
+		t.Errorf("Unexpected append-only file info %v", fields)
+	}
+
+	// the writes after the rewrite are appended to the new file
+	dataStore.Set("counter", "100", NoExpiration)
+	dataStore.Close()
+
+	restored := openAOFStore(t, path)
+	defer restored.Close()
+
+	if value, _ := restored.Get("counter"); value != "100" {
+		t.Errorf("Expected counter 100, got %q", value)
+	}
+	if list, _ := restored.List("list"); !reflect.DeepEqual(list, []string{"a", "b"}) {
+		t.Errorf("Expected list [a b], got %v", list)
+	}
+	if hash, _ := restored.Hash("hash"); !reflect.DeepEqual(hash, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("Expected restored hash, got %v", hash)
+	}
+	if item := restored.shard("hash").values["hash"]; item.expire != expire {
+		t.Errorf("Expected expiration %d, got %d", expire, item.expire)
+	}
+	if size := restored.Size(); size != 3 {
+		t.Errorf("Expected 3 keys, got %d", size)
+	}
+}
+
+func TestRewriteAppendOnlyConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.aof")
+
+	dataStore, err := NewWithOptions(WithShards(4), WithWorkers(NoWorkers), WithAppendOnly(path, FsyncNo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := "key" + strconv.Itoa(i%20)
+				dataStore.LPush("list"+strconv.Itoa(w), strconv.Itoa(i))
+				dataStore.Set(key, strconv.Itoa(i), NoExpiration)
+			}
+		}(w)
+	}
+	for i := 0; i < 5; i++ {
+		if err := dataStore.RewriteAppendOnly(); err != nil && err != errRewriting {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+
+	expected := map[string]interface{}{}
+	for _, key := range dataStore.Keys() {
+		if list, err := dataStore.List(key); err == nil {
+			expected[key] = list
+		} else {
+			expected[key], _ = dataStore.Get(key)
+		}
+	}
+	dataStore.Close()
+
+	restored, err := NewWithOptions(WithShards(4), WithWorkers(NoWorkers), WithAppendOnly(path, FsyncNo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	for key, value := range expected {
+		var got interface{}
+		if list, err := restored.List(key); err == nil {
+			got = list
+		} else {
+			got, _ = restored.Get(key)
+		}
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("Expected %s to be %v, got %v", key, value, got)
+		}
+	}
+	if restored.Size() != len(expected) {
+		t.Errorf("Expected %d keys, got %d", len(expected), restored.Size())
+	}
+}
+
+func TestRewriteNeeded(t *testing.T) {
+	aof := &appendOnly{size: 150, baseSize: 100}
+
+	cases := []struct {
+		percentage int
+		minSize    int64
+		expected   bool
+	}{
+		{50, 0, true},
+		{100, 0, false},
+		{50, 200, false},
+	}
+	for _, c := range cases {
+		if needed := aof.rewriteNeeded(c.percentage, c.minSize); needed != c.expected {
+			t.Errorf("%d%%, min size %d: expected %v, got %v", c.percentage, c.minSize, c.expected, needed)
+		}
+	}
+
+	dataStore, _ := NewWithOptions(WithWorkers(NoWorkers))
+	defer dataStore.Close()
+	if err := dataStore.RewriteAppendOnly(); err != errNoAOF {
+		t.Errorf("Expected %v, got %v", errNoAOF, err)
+	}
+}
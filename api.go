@@ -0,0 +1,435 @@
+package inmemory
+
+import (
+	"strconv"
+	"time"
+)
+
+// Special ttl values for the items.
+const (
+	// NoExpiration makes the item live until it's removed or evicted.
+	NoExpiration time.Duration = 0
+	// DefaultExpiration makes the item expire after the default ttl.
+	DefaultExpiration time.Duration = -1
+)
+
+// expireAt returns absolute expiration time in seconds, Unix time for the ttl.
+// Expiration is tracked with seconds precision, so ttl is rounded up.
+func expireAt(ttl time.Duration) (int64, error) {
+	switch {
+	case ttl == NoExpiration:
+		return 0, nil
+	case ttl == DefaultExpiration:
+		ttl = time.Duration(defaultExpiration) * time.Second
+	case ttl < 0:
+		return 0, ErrTTLValue
+	}
+
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	return time.Now().Unix() + seconds, nil
+}
+
+// Get retrieves string value by given key.
+// If item was successfully read, it will be updated as the most recently used in cache.
+func (dataStore *DataStore) Get(key string) (string, error) {
+
+	shard := dataStore.shard(key)
+	shard.lockAccess()
+	defer shard.unlockAccess()
+
+	item, ok := shard.lookup(key)
+	if !ok {
+		return "", ErrNoItem
+	}
+
+	// convert item's value to the string
+	result, ok := item.Value.(string)
+	if !ok {
+		return "", ErrNotString
+	}
+
+	// updating cache to set the current item as the most recently used
+	shard.touch(key, item)
+	return result, nil
+}
+
+// Set stores string value by given key, replacing the previous item.
+// The item expires after ttl, NoExpiration and DefaultExpiration are accepted.
+func (dataStore *DataStore) Set(key, value string, ttl time.Duration) error {
+
+	expire, err := expireAt(ttl)
+	if err != nil {
+		return err
+	}
+
+	if dataStore.outOfMemory() {
+		return ErrOOM
+	}
+
+	item := &Item{
+		Value:  value,
+		expire: expire,
+		el:     nil,
+	}
+
+	shard := dataStore.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if err := dataStore.write(key, func() interface{} { return value }, "SET", key, value, strconv.FormatInt(expire, 10)); err != nil {
+		return err
+	}
+
+	// store the item and update the cache
+	shard.set(key, item)
+	return nil
+}
+
+// Size returns number of all keys in the data store.
+func (dataStore *DataStore) Size() int {
+	return dataStore.size()
+}
+
+// Remove deletes the item by given key.
+func (dataStore *DataStore) Remove(key string) error {
+
+	shard := dataStore.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if _, ok := shard.get(key); !ok {
+		return ErrNoItem
+	}
+	if err := dataStore.write(key, removed, "REMOVE", key); err != nil {
+		return err
+	}
+	return shard.remove(key, Removed)
+}
+
+// RemoveBatch deletes the items by given keys, missing keys are skipped.
+// The first error of the backing store is returned, the rest of the keys
+// are removed anyway.
+func (dataStore *DataStore) RemoveBatch(keys ...string) error {
+	var result error
+	for _, key := range keys {
+		if err := dataStore.Remove(key); err != nil && err != ErrNoItem && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// Keys returns all keys which are currently in the data store.
+func (dataStore *DataStore) Keys() []string {
+
+	dataStore.rlockAll()
+	defer dataStore.runlockAll()
+
+	var keys []string
+
+	// fill list of strings with current keys of all shards
+	for _, shard := range dataStore.shards {
+		for k := range shard.values {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Expire updates ttl of the item by given key.
+// NoExpiration and DefaultExpiration are accepted.
+func (dataStore *DataStore) Expire(key string, ttl time.Duration) error {
+
+	expire, err := expireAt(ttl)
+	if err != nil {
+		return err
+	}
+
+	shard := dataStore.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	item, ok := shard.get(key)
+	if !ok {
+		return ErrNoItem
+	}
+	if err := dataStore.write(key, nil, "EXPIREAT", key, strconv.FormatInt(expire, 10)); err != nil {
+		return err
+	}
+
+	shard.expire(key, item, expire)
+	return nil
+}
+
+// LSet updates value in the list by given key and index.
+// Only existing values can be updated, so the index has to be in the range.
+// List item will be updated as the most recently used in the cache.
+func (dataStore *DataStore) LSet(key string, index int, value string) error {
+
+	if dataStore.outOfMemory() {
+		return ErrOOM
+	}
+
+	shard := dataStore.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	item, ok := shard.get(key)
+	if !ok {
+		return ErrNoItem
+	}
+
+	// convert the item to the list type
+	list, ok := item.Value.([]string)
+	if !ok {
+		return ErrNotList
+	}
+	if index >= len(list) || index < 0 {
+		return ErrIndexRange
+	}
+
+	err := dataStore.write(key, func() interface{} {
+		updated := append([]string(nil), list...)
+		updated[index] = value
+		return updated
+	}, "LSET", key, strconv.Itoa(index), value)
+	if err != nil {
+		return err
+	}
+
+	list[index] = value
+
+	// update the cache
+	shard.touch(key, item)
+	return nil
+}
+
+// LPush appends value to the list by given key.
+// If there is no list, the new one is created.
+// List item will be updated as the most recently used in the cache.
+func (dataStore *DataStore) LPush(key, value string) error {
+
+	if dataStore.outOfMemory() {
+		return ErrOOM
+	}
+
+	shard := dataStore.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	item, ok := shard.get(key)
+
+	// create new list, if there is none
+	if !ok {
+		if err := dataStore.write(key, func() interface{} { return []string{value} }, "LPUSH", key, value); err != nil {
+			return err
+		}
+
+		newItem := &Item{
+			Value: []string{value},
+			el:    nil,
+		}
+		shard.set(key, newItem)
+		return nil
+	}
+
+	// convert existing item to the list type
+	list, ok := item.Value.([]string)
+	if !ok {
+		return ErrNotList
+	}
+
+	err := dataStore.write(key, func() interface{} {
+		return append(append([]string(nil), list...), value)
+	}, "LPUSH", key, value)
+	if err != nil {
+		return err
+	}
+
+	item.Value = append(list, value)
+
+	// update the cache
+	shard.touch(key, item)
+	return nil
+}
+
+// LGet returns value from the list by given key and index.
+// List item will be updated as the most recently used in the cache.
+func (dataStore *DataStore) LGet(key string, index int) (string, error) {
+
+	shard := dataStore.shard(key)
+	shard.lockAccess()
+	defer shard.unlockAccess()
+
+	item, ok := shard.lookup(key)
+	if !ok {
+		return "", ErrNoItem
+	}
+
+	// convert existing item to the list type
+	list, ok := item.Value.([]string)
+	if !ok {
+		return "", ErrNotList
+	}
+	if index >= len(list) || index < 0 {
+		return "", ErrIndexRange
+	}
+
+	// update the cache
+	shard.touch(key, item)
+	return list[index], nil
+}
+
+// List returns the copy of all values of the list by given key.
+// List item will be updated as the most recently used in the cache.
+func (dataStore *DataStore) List(key string) ([]string, error) {
+
+	shard := dataStore.shard(key)
+	shard.lockAccess()
+	defer shard.unlockAccess()
+
+	item, ok := shard.lookup(key)
+	if !ok {
+		return nil, ErrNoItem
+	}
+
+	list, ok := item.Value.([]string)
+	if !ok {
+		return nil, ErrNotList
+	}
+
+	shard.touch(key, item)
+	return append([]string(nil), list...), nil
+}
+
+// HSet updates or creates the value in the hash by given key.
+// If there is no hash item, it will be created.
+// Hash item will be updated as the most recently used in the cache.
+func (dataStore *DataStore) HSet(key, hashKey, value string) error {
+
+	if dataStore.outOfMemory() {
+		return ErrOOM
+	}
+
+	shard := dataStore.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	item, ok := shard.get(key)
+
+	// create new hash if it doesn't exist
+	if !ok {
+		if err := dataStore.write(key, func() interface{} { return map[string]string{hashKey: value} }, "HSET", key, hashKey, value); err != nil {
+			return err
+		}
+
+		newItem := &Item{
+			Value: map[string]string{
+				hashKey: value,
+			},
+			el: nil,
+		}
+
+		// set the value to new hash and add it to the cache
+		shard.set(key, newItem)
+		return nil
+	}
+
+	// convert existing item to the map type
+	hash, ok := item.Value.(map[string]string)
+	if !ok {
+		return ErrNotHash
+	}
+
+	err := dataStore.write(key, func() interface{} {
+		updated := make(map[string]string, len(hash)+1)
+		for k, v := range hash {
+			updated[k] = v
+		}
+		updated[hashKey] = value
+		return updated
+	}, "HSET", key, hashKey, value)
+	if err != nil {
+		return err
+	}
+
+	hash[hashKey] = value
+	shard.touch(key, item)
+	return nil
+}
+
+// HGet retrieves value from the hash by given key and hash key.
+// Hash item will be updated as the most recently used in the cache.
+func (dataStore *DataStore) HGet(key, hashKey string) (string, error) {
+
+	shard := dataStore.shard(key)
+	shard.lockAccess()
+	defer shard.unlockAccess()
+
+	item, ok := shard.lookup(key)
+	if !ok {
+		return "", ErrNoItem
+	}
+
+	// convert existing item to the hash type
+	hash, ok := item.Value.(map[string]string)
+	if !ok {
+		return "", ErrNotHash
+	}
+	result, ok := hash[hashKey]
+	if !ok {
+		return "", ErrNoKeyHash
+	}
+
+	// update the cache
+	shard.touch(key, item)
+	return result, nil
+}
+
+// Hash returns the copy of the hash by given key.
+// Hash item will be updated as the most recently used in the cache.
+func (dataStore *DataStore) Hash(key string) (map[string]string, error) {
+
+	shard := dataStore.shard(key)
+	shard.lockAccess()
+	defer shard.unlockAccess()
+
+	item, ok := shard.lookup(key)
+	if !ok {
+		return nil, ErrNoItem
+	}
+
+	hash, ok := item.Value.(map[string]string)
+	if !ok {
+		return nil, ErrNotHash
+	}
+
+	result := make(map[string]string, len(hash))
+	for k, v := range hash {
+		result[k] = v
+	}
+
+	shard.touch(key, item)
+	return result, nil
+}
+
+// Freq returns the access frequency counter of the item by given key.
+// It's available only with LFU eviction policy.
+func (dataStore *DataStore) Freq(key string) (int, error) {
+
+	shard := dataStore.shard(key)
+
+	if _, ok := shard.policy.(lfu); !ok {
+		return 0, ErrNotLFU
+	}
+
+	shard.RLock()
+	defer shard.RUnlock()
+
+	item, ok := shard.get(key)
+	if !ok {
+		return 0, ErrNoItem
+	}
+
+	return int(lfuDecr(item)), nil
+}
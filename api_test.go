@@ -0,0 +1,177 @@
+package inmemory
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func setupTestStore(t *testing.T) *DataStore {
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	t.Cleanup(func() { dataStore.Close() })
+	return dataStore
+}
+
+func TestTypedStrings(t *testing.T) {
+	dataStore := setupTestStore(t)
+
+	if err := dataStore.Set("key", "value", NoExpiration); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	value, err := dataStore.Get("key")
+	if err != nil || value != "value" {
+		t.Errorf("Expected value, got: %q, %v", value, err)
+	}
+	if _, err := dataStore.Get("missing"); err != ErrNoItem {
+		t.Errorf("Expected %v, got: %v", ErrNoItem, err)
+	}
+
+	dataStore.LPush("list", "a")
+	if _, err := dataStore.Get("list"); err != ErrNotString {
+		t.Errorf("Expected %v, got: %v", ErrNotString, err)
+	}
+
+	if size := dataStore.Size(); size != 2 {
+		t.Errorf("Expected size 2, got: %d", size)
+	}
+	keys := dataStore.Keys()
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"key", "list"}) {
+		t.Errorf("Expected keys [key list], got: %v", keys)
+	}
+
+	if err := dataStore.Remove("key"); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+	if err := dataStore.Remove("key"); err != ErrNoItem {
+		t.Errorf("Expected %v, got: %v", ErrNoItem, err)
+	}
+	dataStore.RemoveBatch("list", "missing")
+	if size := dataStore.Size(); size != 0 {
+		t.Errorf("Expected empty data store, got size: %d", size)
+	}
+}
+
+func TestTypedExpiration(t *testing.T) {
+	dataStore := setupTestStore(t)
+	now := time.Now().Unix()
+
+	cases := []struct {
+		name     string
+		ttl      time.Duration
+		expected int64
+	}{
+		{"no expiration", NoExpiration, 0},
+		{"default expiration", DefaultExpiration, now + defaultExpiration},
+		{"seconds", 10 * time.Second, now + 10},
+		{"rounded up", 1500 * time.Millisecond, now + 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := dataStore.Set("key", "value", tc.ttl); err != nil {
+				t.Fatalf("Set failed: %v", err)
+			}
+			// allow the second to change during the test
+			if expire := dataStore.shard("key").values["key"].expire; expire != tc.expected && expire != tc.expected+1 {
+				t.Errorf("Expected expiration %d, got: %d", tc.expected, expire)
+			}
+		})
+	}
+
+	if err := dataStore.Set("key", "value", -time.Second); err != ErrTTLValue {
+		t.Errorf("Expected %v, got: %v", ErrTTLValue, err)
+	}
+
+	if err := dataStore.Expire("key", NoExpiration); err != nil {
+		t.Errorf("Expire failed: %v", err)
+	}
+	if _, ok := dataStore.shard("key").expires["key"]; ok {
+		t.Error("Expected the expiration to be removed")
+	}
+	if err := dataStore.Expire("missing", time.Second); err != ErrNoItem {
+		t.Errorf("Expected %v, got: %v", ErrNoItem, err)
+	}
+}
+
+func TestTypedList(t *testing.T) {
+	dataStore := setupTestStore(t)
+
+	dataStore.LPush("list", "a")
+	dataStore.LPush("list", "b")
+	if err := dataStore.LSet("list", 1, "c"); err != nil {
+		t.Errorf("LSet failed: %v", err)
+	}
+	if value, err := dataStore.LGet("list", 1); err != nil || value != "c" {
+		t.Errorf("Expected c, got: %q, %v", value, err)
+	}
+
+	list, err := dataStore.List("list")
+	if err != nil || !reflect.DeepEqual(list, []string{"a", "c"}) {
+		t.Errorf("Expected [a c], got: %v, %v", list, err)
+	}
+
+	// the returned list is a copy
+	list[0] = "changed"
+	if value, _ := dataStore.LGet("list", 0); value != "a" {
+		t.Errorf("Expected the list not to be changed, got: %q", value)
+	}
+
+	dataStore.Set("key", "value", NoExpiration)
+
+	errorCases := []struct {
+		name          string
+		run           func() error
+		expectedError error
+	}{
+		{"lset missing", func() error { return dataStore.LSet("missing", 0, "a") }, ErrNoItem},
+		{"lset not list", func() error { return dataStore.LSet("key", 0, "a") }, ErrNotList},
+		{"lset out of range", func() error { return dataStore.LSet("list", 2, "a") }, ErrIndexRange},
+		{"lpush not list", func() error { return dataStore.LPush("key", "a") }, ErrNotList},
+		{"lget negative index", func() error { _, err := dataStore.LGet("list", -1); return err }, ErrIndexRange},
+		{"list not list", func() error { _, err := dataStore.List("key"); return err }, ErrNotList},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.run(); err != tc.expectedError {
+				t.Errorf("Expected %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestTypedHash(t *testing.T) {
+	dataStore := setupTestStore(t)
+
+	dataStore.HSet("hash", "a", "1")
+	dataStore.HSet("hash", "b", "2")
+	if value, err := dataStore.HGet("hash", "b"); err != nil || value != "2" {
+		t.Errorf("Expected 2, got: %q, %v", value, err)
+	}
+	if _, err := dataStore.HGet("hash", "c"); err != ErrNoKeyHash {
+		t.Errorf("Expected %v, got: %v", ErrNoKeyHash, err)
+	}
+
+	hash, err := dataStore.Hash("hash")
+	expected := map[string]string{"a": "1", "b": "2"}
+	if err != nil || !reflect.DeepEqual(hash, expected) {
+		t.Errorf("Expected %v, got: %v, %v", expected, hash, err)
+	}
+
+	// the returned hash is a copy
+	hash["a"] = "changed"
+	if value, _ := dataStore.HGet("hash", "a"); value != "1" {
+		t.Errorf("Expected the hash not to be changed, got: %q", value)
+	}
+
+	dataStore.Set("key", "value", NoExpiration)
+	if err := dataStore.HSet("key", "a", "1"); err != ErrNotHash {
+		t.Errorf("Expected %v, got: %v", ErrNotHash, err)
+	}
+	if _, err := dataStore.Hash("key"); err != ErrNotHash {
+		t.Errorf("Expected %v, got: %v", ErrNotHash, err)
+	}
+}
@@ -0,0 +1,168 @@
+package inmemory
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackingStore is the durable storage the data store sits in front of.
+// The write commands pass the new values of the changed keys to it.
+// The evicted and expired keys are not removed from the backing store.
+type BackingStore interface {
+	// Store saves the writes. Value of the write is string, []string or
+	// map[string]string, nil value means the key was removed.
+	Store(writes []Write) error
+}
+
+// Write is the change of the key written to the backing store.
+type Write struct {
+	Key   string
+	Value interface{}
+}
+
+// writeQueue holds the pending writes of the write-behind mode.
+// Only the latest value of every key is kept.
+type writeQueue struct {
+	sync.Mutex
+	pending map[string]interface{}
+	// flushing is held while the writes are stored, so the older value
+	// of the key is never stored after the newer one
+	flushing sync.Mutex
+}
+
+func newWriteQueue() *writeQueue {
+	return &writeQueue{
+		pending: make(map[string]interface{}),
+	}
+}
+
+// add queues the write, replacing the pending value of the key.
+func (queue *writeQueue) add(key string, value interface{}) {
+	queue.Lock()
+	defer queue.Unlock()
+
+	queue.pending[key] = value
+}
+
+// size returns number of the pending writes.
+func (queue *writeQueue) size() int {
+	queue.Lock()
+	defer queue.Unlock()
+
+	return len(queue.pending)
+}
+
+// take removes up to n pending writes from the queue.
+func (queue *writeQueue) take(n int) []Write {
+	queue.Lock()
+	defer queue.Unlock()
+
+	var writes []Write
+	for key, value := range queue.pending {
+		if len(writes) == n {
+			break
+		}
+		writes = append(writes, Write{Key: key, Value: value})
+		delete(queue.pending, key)
+	}
+	return writes
+}
+
+// retry returns the failed writes to the queue,
+// unless the keys were changed again meanwhile.
+func (queue *writeQueue) retry(writes []Write) {
+	queue.Lock()
+	defer queue.Unlock()
+
+	for _, write := range writes {
+		if _, ok := queue.pending[write.Key]; !ok {
+			queue.pending[write.Key] = write.Value
+		}
+	}
+}
+
+// write passes the change of the key to the backing store and logs the
+// record of the command to the append-only file. value returns the new
+// value of the key, it's called only if the backing store is set and has
+// to return the copy, which isn't changed by the data store later. nil value
+// means only the ttl is changed, which isn't passed to the backing store.
+// It's called with the shard locked, before the change is applied.
+// The write-through store is written first, so its failure leaves the data
+// unchanged, the write-behind queue gets the change after it's logged.
+// The successful writes are counted as the changes since the last backup.
+func (dataStore *DataStore) write(key string, value func() interface{}, record ...string) error {
+
+	// the running snapshot saves the item as it was before the change
+	shard := dataStore.shard(key)
+	shard.preserve(key)
+
+	backing := dataStore.config.backing != nil && value != nil
+	if backing && !dataStore.config.writeBehind {
+		if err := dataStore.config.backing.Store([]Write{{Key: key, Value: value()}}); err != nil {
+			return err
+		}
+	}
+
+	if err := dataStore.aof.append(shard, record...); err != nil {
+		if backing && !dataStore.config.writeBehind {
+			log.Printf("Key %s is changed in the backing store, but not in the data store: %v\n", key, err)
+		}
+		return err
+	}
+
+	if backing && dataStore.config.writeBehind {
+		dataStore.writes.add(key, value())
+	}
+	atomic.AddInt64(&dataStore.counters.changes, 1)
+	return nil
+}
+
+// removed is the value of the removed key.
+func removed() interface{} {
+	return nil
+}
+
+// Flush writes all the pending changes to the backing store in write-behind
+// mode. The changes which failed to be written stay in the queue.
+func (dataStore *DataStore) Flush() error {
+
+	if !dataStore.config.writeBehind {
+		return nil
+	}
+
+	queue := dataStore.writes
+	queue.flushing.Lock()
+	defer queue.flushing.Unlock()
+
+	for {
+		writes := queue.take(dataStore.config.writeBatch)
+		if len(writes) == 0 {
+			return nil
+		}
+		if err := dataStore.config.backing.Store(writes); err != nil {
+			queue.retry(writes)
+			return err
+		}
+	}
+}
+
+// writebehind is the worker writing the pending changes to the backing store.
+func (dataStore *DataStore) writebehind() {
+
+	ticker := time.NewTicker(dataStore.config.writeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-dataStore.done:
+			return
+		}
+
+		if err := dataStore.Flush(); err != nil {
+			log.Println("Error writing to backing store", err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package inmemory
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errStoreFailed = errors.New("store failed")
+
+// recordingStore records the batches of writes and fails while fail is set.
+type recordingStore struct {
+	sync.Mutex
+	batches [][]Write
+	fail    bool
+}
+
+func (store *recordingStore) Store(writes []Write) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if store.fail {
+		return errStoreFailed
+	}
+	store.batches = append(store.batches, writes)
+	return nil
+}
+
+// values returns the latest written values by the keys.
+func (store *recordingStore) values() map[string]interface{} {
+	store.Lock()
+	defer store.Unlock()
+
+	values := make(map[string]interface{})
+	for _, batch := range store.batches {
+		for _, write := range batch {
+			values[write.Key] = write.Value
+		}
+	}
+	return values
+}
+
+func TestWriteThrough(t *testing.T) {
+	store := &recordingStore{}
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithWriteThrough(store))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	dataStore.Set("string", "value", NoExpiration)
+	dataStore.LPush("list", "a")
+	dataStore.LPush("list", "b")
+	dataStore.LSet("list", 0, "c")
+	dataStore.HSet("hash", "a", "1")
+	dataStore.HSet("hash", "b", "2")
+	dataStore.Set("removed", "value", NoExpiration)
+	dataStore.Remove("removed")
+
+	expected := map[string]interface{}{
+		"string":  "value",
+		"list":    []string{"c", "b"},
+		"hash":    map[string]string{"a": "1", "b": "2"},
+		"removed": nil,
+	}
+	if values := store.values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected written values: %v, got: %v", expected, values)
+	}
+	if len(store.batches) != 8 {
+		t.Errorf("Expected every write to be stored, got %d batches", len(store.batches))
+	}
+
+	// the failed writes are not applied
+	store.fail = true
+	if err := dataStore.Set("string", "new", NoExpiration); err != errStoreFailed {
+		t.Errorf("Expected %v, got: %v", errStoreFailed, err)
+	}
+	if err := dataStore.HSet("hash", "a", "new"); err != errStoreFailed {
+		t.Errorf("Expected %v, got: %v", errStoreFailed, err)
+	}
+	if err := dataStore.RemoveBatch("list", "missing"); err != errStoreFailed {
+		t.Errorf("Expected %v, got: %v", errStoreFailed, err)
+	}
+	if value, _ := dataStore.Get("string"); value != "value" {
+		t.Errorf("Expected old value, got: %q", value)
+	}
+	if value, _ := dataStore.HGet("hash", "a"); value != "1" {
+		t.Errorf("Expected old value, got: %q", value)
+	}
+	if _, err := dataStore.List("list"); err != nil {
+		t.Errorf("Expected the list not to be removed, got: %v", err)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	store := &recordingStore{}
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithWriteBehind(store, time.Hour, 2))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	// the changes of the same key are coalesced
+	for _, value := range []string{"a", "b", "c"} {
+		dataStore.LPush("list", value)
+	}
+	dataStore.Set("string", "value", NoExpiration)
+	dataStore.HSet("hash", "a", "1")
+
+	if len(store.batches) != 0 {
+		t.Errorf("Expected no writes before flush, got: %v", store.batches)
+	}
+
+	// the failed writes are retried
+	store.fail = true
+	if err := dataStore.Flush(); err != errStoreFailed {
+		t.Errorf("Expected %v, got: %v", errStoreFailed, err)
+	}
+	store.fail = false
+
+	// the newer value is not replaced by the failed one
+	dataStore.Set("string", "new", NoExpiration)
+
+	if err := dataStore.Flush(); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"list":   []string{"a", "b", "c"},
+		"string": "new",
+		"hash":   map[string]string{"a": "1"},
+	}
+	if values := store.values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected written values: %v, got: %v", expected, values)
+	}
+	if len(store.batches) != 2 {
+		t.Errorf("Expected 2 batches of at most 2 writes, got: %v", store.batches)
+	}
+
+	// the pending changes are written on close
+	dataStore.Remove("list")
+	dataStore.Close()
+	if value, ok := store.values()["list"]; !ok || value != nil {
+		t.Errorf("Expected the list to be removed on close, got: %v", value)
+	}
+}
+
+func TestWriteBehindNotLogged(t *testing.T) {
+	store := &recordingStore{}
+	path := filepath.Join(t.TempDir(), "data.aof")
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithWriteBehind(store, time.Hour, 10), WithAppendOnly(path, FsyncAlways))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	// the change which failed to be logged isn't queued
+	dataStore.aof.file.Close()
+	if err := dataStore.Set("key", "value", NoExpiration); err == nil {
+		t.Fatal("Expected the append-only file write to fail")
+	}
+	dataStore.Flush()
+	if len(store.batches) != 0 {
+		t.Errorf("Expected no writes, got: %v", store.batches)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Couldn't create file store: %v", err)
+	}
+
+	err = store.Store([]Write{
+		{Key: "string", Value: "value"},
+		{Key: "../list", Value: []string{"a", "b"}},
+		{Key: "hash", Value: map[string]string{"a": "1"}},
+	})
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	for key, expected := range map[string]interface{}{
+		"string":  "value",
+		"../list": []string{"a", "b"},
+		"hash":    map[string]string{"a": "1"},
+	} {
+		if value, err := store.Get(key); err != nil || !reflect.DeepEqual(value, expected) {
+			t.Errorf("Expected %v by key %q, got: %v, %v", expected, key, value, err)
+		}
+	}
+
+	if err := store.Store([]Write{{Key: "string"}, {Key: "missing"}}); err != nil {
+		t.Errorf("Store failed: %v", err)
+	}
+	if _, err := store.Get("string"); err != ErrNoItem {
+		t.Errorf("Expected %v, got: %v", ErrNoItem, err)
+	}
+
+	// the file store loads the written values
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithWriteThrough(store), WithLoader(store, NoExpiration))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	dataStore.Set("key", "value", NoExpiration)
+	dataStore.RemoveBatch("key")
+	store.Store([]Write{{Key: "key", Value: "stored"}})
+	if value, err := dataStore.GetOrLoad("key"); err != nil || value != "stored" {
+		t.Errorf("Expected stored value, got: %q, %v", value, err)
+	}
+	if _, err := dataStore.GetOrLoad("hash"); err != ErrNotString {
+		t.Errorf("Expected %v, got: %v", ErrNotString, err)
+	}
+}
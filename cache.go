@@ -5,10 +5,10 @@ package inmemory
 import (
 	"container/list"
 	"errors"
-	"log"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,52 +27,130 @@ var (
 		"LGET":         LGet,
 		"HSET":         HSet,
 		"HGET":         HGet,
+		"OBJECT":       Object,
+		"INFO":         Info,
+		"CONFIG":       Config,
+		"SLOWLOG":      SlowLog,
+		"CLIENT":       ClientCommand,
+		"SAVE":         Save,
+		"BGSAVE":       BackgroundSave,
+		"LASTSAVE":     LastSave,
+		"BGREWRITEAOF": BackgroundRewriteAppendOnly,
 	}
 
 	// default server configuration
 
+	// directory to place backups
+	backupDir = ".backups"
 	// number of backup files to keep
 	backupNumber = 2
-	// interval for backup service running
-	backupInterval = 300 * time.Second
+	// the backup is saved after the number of changes in the interval
+	defaultSaveRules = []SaveRule{{time.Hour, 1}, {5 * time.Minute, 100}, {time.Minute, 10000}}
+	// the failed backup is retried after the delay
+	saveRetryDelay = 5 * time.Second
 	// interval for memory cleanup service
 	cleanupInterval = 5 * time.Second
 	// default expiration for the item in seconds
 	defaultExpiration int64 = 1800
+	// default number of independently locked shards of the data store
+	defaultShards = 16
+	// number of items evicted by memoryd on each check
+	evictionBatch = 20
 	// max heap memory for the application
 	maxMemory = 5000000
 	// memory check interval in seconds
 	memoryCheckInterval = 5
+	// commands running longer are recorded in the slow log
+	slowLogThreshold = 10 * time.Millisecond
+	// number of the latest slow commands to keep
+	slowLogSize = 128
+	// growth of the append-only file in percents of its size after
+	// the last rewrite, which triggers the next rewrite
+	aofRewritePercent = 100
+	// the append-only file is not rewritten until it reaches the size in bytes
+	aofRewriteMinSize int64 = 64 << 20
 
 	// Error objects used by application
 	errNoSuchCommand  = errors.New("no such command")
 	errArgumentNumber = errors.New("wrong number of arguments")
-	errNoItem         = errors.New("no such item")
 	errTTLFormat      = errors.New("ttl should be a number")
-	errTTLValue       = errors.New("ttl should be >= 0")
 	errIndexFormat    = errors.New("index should be a number")
-	errIndexRange     = errors.New("index out of range")
-	errNotString      = errors.New("not a string")
-	errNotList        = errors.New("not a list")
-	errNotHash        = errors.New("not a hash")
-	errNoKeyHash      = errors.New("no such key in the hash")
+	errCountFormat    = errors.New("count should be a number")
+	errIDFormat       = errors.New("id should be a number")
+	errNoSubcommand   = errors.New("no such subcommand")
+	errShardsNumber   = errors.New("number of shards should be > 0")
+)
+
+// Errors returned by the data store methods and the commands.
+// They can be compared with the returned errors to handle the specific cases.
+var (
+	ErrNoItem     = errors.New("no such item")
+	ErrTTLValue   = errors.New("ttl should be >= 0")
+	ErrIndexRange = errors.New("index out of range")
+	ErrNotString  = errors.New("not a string")
+	ErrNotList    = errors.New("not a list")
+	ErrNotHash    = errors.New("not a hash")
+	ErrNoKeyHash  = errors.New("no such key in the hash")
+	ErrNotLFU     = errors.New("lfu eviction policy is not selected")
+	ErrOOM        = errors.New("command not allowed when used memory > maxmemory")
 )
 
 // Item struct holds the actual user's item(string, list, hash).
 // It has expiration in seconds, Unix time. Usually set via time.Now().Unix()
+// Zero expiration means the item never expires.
 // el is the link to the position in cache, for the O(1) cache manipulations.
+// lfu is the access counter with its last decrement time used by the LFU eviction.
+// access is the last access time in nanoseconds used by the approximated LRU,
+// it's first to be 64-bit aligned for atomic operations.
+// size is the size of the value given by the size function of the Cache.
 type Item struct {
-	Value interface{}
-	el    *list.Element
+	access int64
+	Value  interface{}
+	expire int64
+	el     *list.Element
+	lfu    uint32
+	size   int64
 }
 
-// DataStore struct holds all values for this database with LRU caching
-// RWMutex is required for the thread-safe data reading and modification.
+// DataStore struct holds all values for this database with caching
+// driven by the eviction policy.
+// The values are split between the shards by the key hash. Each shard has
+// its own RWMutex for the thread-safe data reading and modification.
 type DataStore struct {
-	sync.RWMutex
-	values      map[string]*Item
-	cache       *list.List
-	ttlCommands chan expiration
+	// counters are first to be 64-bit aligned for atomic operations
+	counters counters
+
+	shards []*shard
+	config config
+	// oom is set to 1 by memoryd when the memory limit is exceeded
+	// and the eviction policy can't free any keys
+	oom int32
+	// loads are the running loads of GetOrLoad
+	loads *loads
+	// writes are the pending writes to the backing store in write-behind mode
+	writes *writeQueue
+	// removals are the removed items waiting for the removal listeners
+	removals *removals
+	// commandMetrics are the calls and the latency of the commands
+	commandMetrics *CommandMetrics
+	// slowLog keeps the latest commands exceeding the latency threshold
+	slowLog *slowLog
+	// monitors receive the feed of the executed commands
+	monitors *monitors
+	// clients are the connected clients
+	clients *clients
+	// aof is the append-only file, nil if it's disabled
+	aof *appendOnly
+	// snapshotting is held while the snapshot is saved
+	snapshotting sync.Mutex
+
+	// done is closed to stop the workers
+	done      chan struct{}
+	workers   sync.WaitGroup
+	closeOnce sync.Once
+	// background are the running BGSAVE and BGREWRITEAOF
+	background sync.WaitGroup
+	closeErr   error
 }
 
 // Client struct holds all info about the client, the last executed command,
@@ -81,33 +159,175 @@ type DataStore struct {
 // can be possibility to switch data stores by the client.
 type Client struct {
 	ds    *DataStore
+	addr  string
 	cmd   string
 	args  []string
 	err   error
 	reply string
+
+	// id and created are set when the client is registered in the data store
+	id      int64
+	created time.Time
+	// activity is read by the other clients with CLIENT LIST
+	activity clientActivity
+}
+
+// Stats struct holds the statistics of the data store usage.
+// Hits and Misses are the numbers of lookups by the read commands
+// which found and didn't find the key.
+// Expired and Evicted are the numbers of the items removed by ttld
+// and by the eviction policy. Commands is the number of executed commands.
+type Stats struct {
+	Hits     uint64
+	Misses   uint64
+	Expired  uint64
+	Evicted  uint64
+	Commands uint64
 }
 
-type expiration struct {
-	command string
-	key     string
-	time    int64
+// HitRate returns the share of the lookups which found the key.
+func (stats Stats) HitRate() float64 {
+	total := stats.Hits + stats.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(total)
 }
 
-// New creates new data store and starts workers for it. Current workers: ttld,
-// persistenced and memoryd.
+// New creates new data store with the default eviction policy and starts
+// workers for it. Current workers: ttld, persistenced and memoryd.
 func New() *DataStore {
+	dataStore, _ := NewWithPolicy(defaultPolicy)
+	return dataStore
+}
+
+// NewWithPolicy creates new data store which evicts the keys according to
+// the policy with the given name, e.g. "allkeys-lru" or "noeviction".
+// Workers are started the same way as for New.
+func NewWithPolicy(policy string) (*DataStore, error) {
+	return NewWithOptions(WithEvictionPolicy(policy))
+}
+
+// NewSharded creates new data store split into the given number of shards.
+// Each shard evicts its keys according to the policy with the given name.
+// More shards allow more commands to run in parallel, but the eviction
+// order becomes approximate, as the policy sees only the keys of its shard.
+// Workers are started the same way as for New.
+func NewSharded(policy string, shards int) (*DataStore, error) {
+	return NewWithOptions(WithEvictionPolicy(policy), WithShards(shards))
+}
+
+// NewWithOptions creates new data store configured by the options.
+// Settings which are not given are taken from the default server
+// configuration. The data store has to be closed with Close to stop
+// its workers.
+func NewWithOptions(options ...Option) (*DataStore, error) {
+
+	config, err := newConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	return newDataStore(config)
+}
+
+// newDataStore creates the data store with the validated configuration
+// and starts its workers. The append-only file is replayed and the backup
+// is restored before that.
+func newDataStore(config config) (*DataStore, error) {
+
+	factory := policies[config.policy]
 
 	dataStore := DataStore{
-		values:      make(map[string]*Item),
-		cache:       list.New(),
-		ttlCommands: make(chan expiration, 15),
+		shards: make([]*shard, config.shards),
+		config: config,
+		counters: counters{
+			started: time.Now(),
+		},
+		loads:          newLoads(),
+		writes:         newWriteQueue(),
+		removals:       newRemovals(),
+		commandMetrics: NewCommandMetrics(),
+		slowLog:        newSlowLog(config.slowLogThreshold, config.slowLogSize),
+		monitors:       newMonitors(),
+		clients:        newClients(),
+		done:           make(chan struct{}),
+	}
+	for i := range dataStore.shards {
+		dataStore.shards[i] = newShard(factory(), dataStore.removals)
 	}
 
-	go dataStore.ttld()
-	go dataStore.persistenced()
-	go dataStore.memoryd()
+	if config.appendOnly != "" {
+		aof, err := dataStore.loadAppendOnly(factory)
+		if err != nil {
+			return nil, err
+		}
+		dataStore.aof = aof
+	}
+	if config.restore {
+		if err := dataStore.restore(); err != nil {
+			dataStore.aof.close()
+			return nil, err
+		}
+	}
+
+	dataStore.start(config.workers&TTLWorker != 0, dataStore.ttld)
+	dataStore.start(config.workers&PersistenceWorker != 0, dataStore.persistenced)
+	dataStore.start(config.workers&MemoryWorker != 0, dataStore.memoryd)
+	dataStore.start(config.writeBehind, dataStore.writebehind)
+	dataStore.start(dataStore.aof != nil && config.appendFsync == FsyncEverySec, dataStore.fsyncd)
+	dataStore.start(dataStore.aof != nil && config.aofRewritePercent > 0, dataStore.rewrited)
+	dataStore.start(true, dataStore.notifier)
+
+	return &dataStore, nil
+}
+
+// start runs the worker if it's enabled in the configuration.
+func (dataStore *DataStore) start(enabled bool, run func()) {
+	if !enabled {
+		return
+	}
 
-	return &dataStore
+	dataStore.workers.Add(1)
+	go func() {
+		defer dataStore.workers.Done()
+		run()
+	}()
+}
+
+// Close stops the workers of the data store and waits for them to finish,
+// the running loads of GetOrLoad are cancelled and waited for as well.
+// The removals made so far are delivered to the removal listeners and
+// the pending writes are written to the backing store. The append-only file
+// is synced and closed, the write commands fail after that.
+// If the final snapshot is enabled, the data is saved to the backup directory.
+// The data store can still be used after Close, but nothing is expired,
+// evicted, saved or loaded in background anymore. Calling Close again has no effect.
+func (dataStore *DataStore) Close() error {
+	dataStore.closeOnce.Do(func() {
+		// no loads are started once done is closed
+		dataStore.loads.Lock()
+		close(dataStore.done)
+		dataStore.loads.Unlock()
+
+		dataStore.workers.Wait()
+		dataStore.background.Wait()
+
+		// deliver the removals made by the stopped workers
+		dataStore.removals.close()
+		dataStore.removals.deliver()
+
+		dataStore.closeErr = dataStore.Flush()
+		if err := dataStore.aof.close(); dataStore.closeErr == nil {
+			dataStore.closeErr = err
+		}
+
+		if dataStore.config.finalSnapshot {
+			if err := dataStore.backup(); dataStore.closeErr == nil {
+				dataStore.closeErr = err
+			}
+		}
+	})
+	return dataStore.closeErr
 }
 
 // NewClient creates client for the given datastore.
@@ -119,6 +339,22 @@ func NewClient(dataStore *DataStore) *Client {
 	}
 }
 
+// NewRemoteClient creates client for the given datastore, connected from
+// the network address. The address is reported by the slow log and CLIENT LIST.
+// The client has to be closed with Close when it's disconnected.
+func NewRemoteClient(dataStore *DataStore, addr string) *Client {
+	client := NewClient(dataStore)
+	client.addr = addr
+	dataStore.clients.register(client)
+	return client
+}
+
+// Close disconnects the remote client from the data store.
+// It has no effect for the client created by NewClient.
+func (client *Client) Close() {
+	client.ds.clients.unregister(client)
+}
+
 // Exec is the command wrapper, giving the client possibility to invoke any command
 // by string name and any correct set of arguments. The result of invokation is stored
 // in the client struct. On correct usage the client's state is updated.
@@ -134,85 +370,154 @@ func (client *Client) Exec(command string, args []string) (reply string, err err
 
 		client.cmd = command
 		client.args = args
+		client.activity.update(command)
+
+		atomic.AddUint64(&client.ds.counters.commands, 1)
+		client.ds.monitors.observe(client, command, args)
 
+		start := time.Now()
 		cmd(client)
+		duration := time.Since(start)
+
+		client.ds.commandMetrics.Observe(command, duration, client.err != nil)
+		client.ds.slowLog.add(client, duration)
+
 		return client.reply, client.err
 	}
 
+	client.ds.commandMetrics.Observe(command, 0, true)
 	return "", errNoSuchCommand
 }
 
+// Stats returns the statistics of the data store since the last ResetStats.
+func (dataStore *DataStore) Stats() Stats {
+	stats := dataStore.totals()
+
+	dataStore.counters.Lock()
+	base := dataStore.counters.statsBase
+	dataStore.counters.Unlock()
+
+	return Stats{
+		Hits:     stats.Hits - base.Hits,
+		Misses:   stats.Misses - base.Misses,
+		Expired:  stats.Expired - base.Expired,
+		Evicted:  stats.Evicted - base.Evicted,
+		Commands: stats.Commands - base.Commands,
+	}
+}
+
+// totals returns the statistics since the data store was created,
+// which are not changed by ResetStats.
+func (dataStore *DataStore) totals() Stats {
+	stats := Stats{
+		Commands: atomic.LoadUint64(&dataStore.counters.commands),
+	}
+	for _, shard := range dataStore.shards {
+		stats.Hits += atomic.LoadUint64(&shard.hits)
+		stats.Misses += atomic.LoadUint64(&shard.misses)
+		stats.Expired += atomic.LoadUint64(&shard.expired)
+		stats.Evicted += atomic.LoadUint64(&shard.evicted)
+	}
+	return stats
+}
+
+// ResetStats sets the statistics returned by Stats to zero.
+// The metrics keep counting from the creation of the data store.
+func (dataStore *DataStore) ResetStats() {
+	stats := dataStore.totals()
+
+	dataStore.counters.Lock()
+	dataStore.counters.statsBase = stats
+	dataStore.counters.Unlock()
+}
+
+// outOfMemory reports whether write commands have to be rejected
+// because the memory limit is reached and nothing can be evicted.
+func (dataStore *DataStore) outOfMemory() bool {
+	return atomic.LoadInt32(&dataStore.oom) == 1
+}
+
 // memoryd is the worker process cleaning the memory its exceeding the limit
 // current implementation is a bit silly :).
-// on each interval 20 least recently items are deleted.
+// on each interval about 20 items chosen by the eviction policies of
+// the shards are deleted.
+// If the policies refuse to evict anything, the write commands are rejected
+// until the memory usage goes below the limit.
 func (dataStore *DataStore) memoryd() {
 	var memStats runtime.MemStats
 
-	threshold := uint64(float64(maxMemory) * 0.9)
-	checkInterval := time.Duration(memoryCheckInterval)
+	threshold := uint64(float64(dataStore.config.maxMemory) * 0.9)
 
-	client := NewClient(dataStore)
+	ticker := time.NewTicker(dataStore.config.memoryCheckInterval)
+	defer ticker.Stop()
+
+	// split the eviction evenly between the shards
+	perShard := (evictionBatch + len(dataStore.shards) - 1) / len(dataStore.shards)
 
 	for {
 		runtime.ReadMemStats(&memStats)
 
 		// naive solution
-		// remove 20 least recently used elements from memory
+		// remove elements picked by the eviction policy from memory
 		if memStats.Alloc > threshold {
-			unusedEntries := make([]string, 0)
-			dataStore.Lock()
-			for i := 0; i < 20; i++ {
-				elPtr := dataStore.cache.Back()
-				if elPtr != nil {
-					key := elPtr.Value.(string)
-					unusedEntries = append(unusedEntries, key)
-				}
+			evicted := 0
+			for _, shard := range dataStore.shards {
+				evicted += shard.evict(perShard)
 			}
-			dataStore.Unlock()
 
-			// remove unused entries
-			if len(unusedEntries) > 0 {
-				client.Exec("REMOVE_BATCH", unusedEntries)
+			switch {
+			case evicted > 0:
+				atomic.StoreInt32(&dataStore.oom, 0)
+			case dataStore.size() == 0:
+				// the memory is not taken by the keys, writes are not to blame
+				atomic.StoreInt32(&dataStore.oom, 0)
+			default:
+				// the policy doesn't allow to free memory, reject the writes
+				atomic.StoreInt32(&dataStore.oom, 1)
 			}
+		} else {
+			atomic.StoreInt32(&dataStore.oom, 0)
 		}
 
-		time.Sleep(checkInterval * time.Second)
+		select {
+		case <-ticker.C:
+		case <-dataStore.done:
+			return
+		}
 	}
 }
 
 // ttld is a worker clearing items with exceeded ttl.
 func (dataStore *DataStore) ttld() {
 
-	ticker := time.NewTicker(cleanupInterval)
-	expirations := make(map[string]int64)
-	client := NewClient(dataStore)
+	ticker := time.NewTicker(dataStore.config.cleanupInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		// catch all ttl related commands to keep data consistent
-		case expiration := <-dataStore.ttlCommands:
-			switch expiration.command {
-			case "DELETE":
-				delete(expirations, expiration.key)
-			case "SET":
-				expirations[expiration.key] = expiration.time
-			default:
-				log.Println("ttld: cannot process command", expiration.command)
-			}
-		// initialize cleanup for entries
 		case <-ticker.C:
-			var expired []string
-			currentTime := time.Now().Unix()
-			for k, v := range expirations {
-				if v < currentTime && v != 0 {
-					expired = append(expired, k)
-				}
-			}
+		case <-dataStore.done:
+			return
+		}
 
-			// delete expired entries from the data store
-			if len(expired) > 0 {
-				client.Exec("REMOVE_BATCH", expired)
-			}
+		currentTime := time.Now().Unix()
+
+		// delete expired entries from the data store
+		for _, shard := range dataStore.shards {
+			shard.removeExpired(currentTime)
 		}
+		dataStore.loads.removeExpired(time.Now().UnixNano())
+	}
+}
+
+// size returns number of all keys in the data store.
+func (dataStore *DataStore) size() int {
+	dataStore.rlockAll()
+	defer dataStore.runlockAll()
+
+	size := 0
+	for _, shard := range dataStore.shards {
+		size += len(shard.values)
 	}
+	return size
 }
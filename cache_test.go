@@ -10,6 +10,7 @@ func TestNew(t *testing.T) {
 	if dataStore == nil {
 		t.Fatal("Couldn't create database.")
 	}
+	dataStore.Close()
 }
 
 func TestTTLD(t *testing.T) {
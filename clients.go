@@ -0,0 +1,292 @@
+package inmemory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNoClient = errors.New("no such client")
+
+// ClientInfo describes the client connected to the data store.
+type ClientInfo struct {
+	ID   int64
+	Name string
+	Addr string
+	// Age is the time since the client was connected
+	Age time.Duration
+	// Idle is the time since the last command of the client
+	Idle time.Duration
+	// Command is the last command of the client, empty until the first one
+	Command string
+	// DB is always 0, as the data store has the single keyspace
+	DB int
+}
+
+// String formats the info in the format of Redis CLIENT LIST, e.g.
+//
+//	id=3 addr=127.0.0.1:5000 name=worker age=10 idle=0 db=0 cmd=get
+func (info ClientInfo) String() string {
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d db=%d cmd=%s",
+		info.ID, info.Addr, info.Name, int64(info.Age/time.Second),
+		int64(info.Idle/time.Second), info.DB, strings.ToLower(info.Command))
+}
+
+// clientActivity is the state of the client read by the other clients.
+type clientActivity struct {
+	sync.Mutex
+	name       string
+	command    string
+	lastActive time.Time
+	// kill is called when the client is killed by the other one
+	kill func()
+}
+
+// update records the command executed by the client.
+func (activity *clientActivity) update(command string) {
+	activity.Lock()
+	defer activity.Unlock()
+
+	activity.command = command
+	activity.lastActive = time.Now()
+}
+
+// clients are the clients connected to the data store.
+type clients struct {
+	sync.Mutex
+	byID   map[int64]*Client
+	lastID int64
+}
+
+func newClients() *clients {
+	return &clients{
+		byID: make(map[int64]*Client),
+	}
+}
+
+// register assigns the id to the client and adds it to the connected ones.
+func (clients *clients) register(client *Client) {
+	clients.Lock()
+	defer clients.Unlock()
+
+	clients.lastID++
+	client.id = clients.lastID
+	client.created = time.Now()
+	client.activity.lastActive = client.created
+	clients.byID[client.id] = client
+}
+
+// unregister removes the client, it returns false if it was removed already.
+func (clients *clients) unregister(client *Client) bool {
+	clients.Lock()
+	defer clients.Unlock()
+
+	if _, ok := clients.byID[client.id]; !ok {
+		return false
+	}
+	delete(clients.byID, client.id)
+	return true
+}
+
+func (clients *clients) len() int {
+	clients.Lock()
+	defer clients.Unlock()
+
+	return len(clients.byID)
+}
+
+// list returns the connected clients ordered by id.
+func (clients *clients) list() []*Client {
+	clients.Lock()
+	result := make([]*Client, 0, len(clients.byID))
+	for _, client := range clients.byID {
+		result = append(result, client)
+	}
+	clients.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].id < result[j].id })
+	return result
+}
+
+// ID returns the id of the client, unique in the data store.
+func (client *Client) ID() int64 {
+	return client.id
+}
+
+// Info returns the description of the client.
+func (client *Client) Info() ClientInfo {
+	client.activity.Lock()
+	defer client.activity.Unlock()
+
+	now := time.Now()
+	return ClientInfo{
+		ID:      client.id,
+		Name:    client.activity.name,
+		Addr:    client.addr,
+		Age:     now.Sub(client.created),
+		Idle:    now.Sub(client.activity.lastActive),
+		Command: client.activity.command,
+	}
+}
+
+// Name returns the name of the client set with SetName.
+func (client *Client) Name() string {
+	client.activity.Lock()
+	defer client.activity.Unlock()
+
+	return client.activity.name
+}
+
+// SetName sets the name of the client reported by CLIENT LIST.
+func (client *Client) SetName(name string) {
+	client.activity.Lock()
+	defer client.activity.Unlock()
+
+	client.activity.name = name
+}
+
+// OnKill sets the function called when the client is killed with KillClient,
+// e.g. to close its connection.
+func (client *Client) OnKill(kill func()) {
+	client.activity.Lock()
+	defer client.activity.Unlock()
+
+	client.activity.kill = kill
+}
+
+// Clients returns the info of the connected clients ordered by id.
+func (dataStore *DataStore) Clients() []ClientInfo {
+	var result []ClientInfo
+	for _, client := range dataStore.clients.list() {
+		result = append(result, client.Info())
+	}
+	return result
+}
+
+// KillClient disconnects the client with the given id. The client is
+// removed from the connected ones and its OnKill function is called.
+func (dataStore *DataStore) KillClient(id int64) error {
+	dataStore.clients.Lock()
+	client, ok := dataStore.clients.byID[id]
+	dataStore.clients.Unlock()
+
+	if !ok || !dataStore.clients.unregister(client) {
+		return errNoClient
+	}
+
+	client.activity.Lock()
+	kill := client.activity.kill
+	client.activity.Unlock()
+
+	if kill != nil {
+		kill()
+	}
+	return nil
+}
+
+// killClients kills the clients matching the filter and returns their number.
+func (dataStore *DataStore) killClients(match func(info ClientInfo) bool) int {
+	killed := 0
+	for _, info := range dataStore.Clients() {
+		if match(info) && dataStore.KillClient(info.ID) == nil {
+			killed++
+		}
+	}
+	return killed
+}
+
+// ClientCommand manages the connections of the data store:
+//
+//	CLIENT LIST
+//	CLIENT INFO
+//	CLIENT SETNAME name
+//	CLIENT GETNAME
+//	CLIENT KILL addr
+//	CLIENT KILL ID|ADDR|NAME value
+//
+// KILL with the single address replies OK, the filtered one replies
+// the number of the killed clients.
+func ClientCommand(client *Client) {
+
+	if len(client.args) < 1 {
+		client.err = errArgumentNumber
+		return
+	}
+
+	subcommand := strings.ToUpper(client.args[0])
+	args := client.args[1:]
+
+	switch subcommand {
+	case "LIST":
+		if len(args) != 0 {
+			client.err = errArgumentNumber
+			return
+		}
+
+		var entries []string
+		for _, info := range client.ds.Clients() {
+			entries = append(entries, info.String())
+		}
+		client.reply = strings.Join(entries, "; ")
+	case "INFO":
+		if len(args) != 0 {
+			client.err = errArgumentNumber
+			return
+		}
+		client.reply = client.Info().String()
+	case "SETNAME":
+		if len(args) != 1 {
+			client.err = errArgumentNumber
+			return
+		}
+		client.SetName(args[0])
+		client.reply = "OK"
+	case "GETNAME":
+		if len(args) != 0 {
+			client.err = errArgumentNumber
+			return
+		}
+		client.reply = client.Name()
+	case "KILL":
+		clientKill(client, args)
+	default:
+		client.err = errNoSubcommand
+	}
+}
+
+func clientKill(client *Client, args []string) {
+
+	switch len(args) {
+	case 1:
+		if client.ds.killClients(func(info ClientInfo) bool { return info.Addr == args[0] }) == 0 {
+			client.err = errNoClient
+			return
+		}
+		client.reply = "OK"
+	case 2:
+		var match func(info ClientInfo) bool
+		switch strings.ToUpper(args[0]) {
+		case "ID":
+			id, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				client.err = errIDFormat
+				return
+			}
+			match = func(info ClientInfo) bool { return info.ID == id }
+		case "ADDR":
+			match = func(info ClientInfo) bool { return info.Addr == args[1] }
+		case "NAME":
+			match = func(info ClientInfo) bool { return info.Name == args[1] }
+		default:
+			client.err = errNoSubcommand
+			return
+		}
+		client.reply = strconv.Itoa(client.ds.killClients(match))
+	default:
+		client.err = errArgumentNumber
+	}
+}
@@ -0,0 +1,112 @@
+package inmemory
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClients(t *testing.T) {
+	dataStore, _ := NewWithOptions(WithWorkers(TTLWorker))
+	defer dataStore.Close()
+
+	first := NewRemoteClient(dataStore, "127.0.0.1:5000")
+	second := NewRemoteClient(dataStore, "127.0.0.1:5001")
+	defer first.Close()
+	defer second.Close()
+
+	if _, err := first.Exec("client", []string{"setname", "worker"}); err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := first.Exec("CLIENT", []string{"GETNAME"}); reply != "worker" {
+		t.Errorf("expected name worker, got %q", reply)
+	}
+	second.Exec("SET", []string{"a", "1"})
+
+	clients := dataStore.Clients()
+	if len(clients) != 2 {
+		t.Fatalf("expected 2 clients, got %v", clients)
+	}
+	if clients[0].ID != first.ID() || clients[0].Name != "worker" || clients[0].Command != "CLIENT" {
+		t.Errorf("unexpected first client %+v", clients[0])
+	}
+	if clients[1].Addr != "127.0.0.1:5001" || clients[1].Command != "SET" {
+		t.Errorf("unexpected second client %+v", clients[1])
+	}
+
+	reply, _ := first.Exec("CLIENT", []string{"LIST"})
+	if entries := strings.Split(reply, "; "); len(entries) != 2 ||
+		!strings.Contains(entries[1], "addr=127.0.0.1:5001 name= ") || !strings.HasSuffix(entries[1], "cmd=set") {
+		t.Errorf("unexpected CLIENT LIST reply %q", reply)
+	}
+	if reply, _ := second.Exec("CLIENT", []string{"INFO"}); !strings.HasSuffix(reply, "cmd=client") {
+		t.Errorf("unexpected CLIENT INFO reply %q", reply)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	dataStore, _ := NewWithOptions(WithWorkers(TTLWorker))
+	defer dataStore.Close()
+
+	admin := NewClient(dataStore)
+
+	killed := map[string]bool{}
+	connect := func(addr, name string) *Client {
+		client := NewRemoteClient(dataStore, addr)
+		client.SetName(name)
+		client.OnKill(func() { killed[addr] = true })
+		return client
+	}
+	first := connect("127.0.0.1:5000", "a")
+	connect("127.0.0.1:5001", "b")
+	connect("127.0.0.1:5002", "b")
+	fourth := connect("127.0.0.1:5003", "c")
+
+	cases := []struct {
+		args  []string
+		reply string
+		err   error
+	}{
+		{[]string{"KILL", "127.0.0.1:5000"}, "OK", nil},
+		{[]string{"KILL", "127.0.0.1:5000"}, "", errNoClient},
+		{[]string{"KILL", "NAME", "b"}, "2", nil},
+		{[]string{"KILL", "ID", "x"}, "", errIDFormat},
+		{[]string{"KILL", "ID", "100"}, "0", nil},
+		{[]string{"KILL", "USER", "c"}, "", errNoSubcommand},
+		{[]string{"KILL"}, "", errArgumentNumber},
+	}
+	for _, c := range cases {
+		reply, err := admin.Exec("CLIENT", c.args)
+		if reply != c.reply || err != c.err {
+			t.Errorf("CLIENT %v: expected %q, %v, got %q, %v", c.args, c.reply, c.err, reply, err)
+		}
+	}
+
+	if len(killed) != 3 || !killed["127.0.0.1:5000"] || killed["127.0.0.1:5003"] {
+		t.Errorf("unexpected killed clients %v", killed)
+	}
+
+	// closing the killed client doesn't change the number of the clients
+	first.Close()
+	if err := dataStore.KillClient(fourth.ID()); err != nil {
+		t.Fatal(err)
+	}
+	// the in-process admin client isn't registered
+	if n := dataStore.clients.len(); n != 0 {
+		t.Errorf("expected no clients, got %d", n)
+	}
+}
+
+func TestLocalClientsNotRegistered(t *testing.T) {
+	dataStore, _ := NewWithOptions(WithWorkers(NoWorkers))
+	defer dataStore.Close()
+
+	for i := 0; i < 10; i++ {
+		NewClient(dataStore).Exec("SET", []string{"key", "value"})
+	}
+	remote := NewRemoteClient(dataStore, "127.0.0.1:5000")
+	defer remote.Close()
+
+	if reply, _ := remote.Exec("CLIENT", []string{"LIST"}); strings.Count(reply, "id=") != 1 {
+		t.Errorf("Expected only the remote client to be listed, got %q", reply)
+	}
+}
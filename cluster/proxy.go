@@ -9,8 +9,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pasiukevich/inmemory"
 
@@ -24,6 +26,7 @@ func main() {
 	addrPtr := flag.String("addr", "127.0.0.1:10000", "Address to listen.")
 	certPtr := flag.String("cert", "server.crt", "Server certificate filepath.")
 	keyPtr := flag.String("key", "server.key", "Server key filepath.")
+	metricsPtr := flag.String("metrics", "", "Address to serve Prometheus metrics on /metrics. Disabled if empty.")
 
 	flag.Parse()
 
@@ -41,6 +44,12 @@ func main() {
 	}
 	pool := inmemory.NewPool(conns, newConnection, servers...)
 
+	// expose the metrics for the monitoring
+	commandMetrics := inmemory.NewCommandMetrics()
+	if *metricsPtr != "" {
+		go serveMetrics(*metricsPtr, commandMetrics, pool, circle)
+	}
+
 	// use the certificates to setup encrypted connections
 	cer, err := tls.LoadX509KeyPair(*certPtr, *keyPtr)
 	if err != nil {
@@ -65,7 +74,7 @@ func main() {
 			log.Println(err)
 			continue
 		}
-		go handleConnection(clientConn, pool, circle)
+		go handleConnection(clientConn, pool, circle, commandMetrics)
 	}
 }
 
@@ -80,7 +89,15 @@ func newConnection(server string) (net.Conn, error) {
 	return conn, nil
 }
 
-func handleConnection(clientConn net.Conn, pool *inmemory.Pool, circle *inmemory.Circle) {
+func serveMetrics(addr string, writers ...inmemory.MetricsWriter) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", inmemory.MetricsHandler(writers...))
+
+	log.Println("Metrics are served on:", addr)
+	log.Println(http.ListenAndServe(addr, mux))
+}
+
+func handleConnection(clientConn net.Conn, pool *inmemory.Pool, circle *inmemory.Circle, commandMetrics *inmemory.CommandMetrics) {
 	// read/writer to the clients connection
 	rw := bufio.NewReadWriter(bufio.NewReader(clientConn), bufio.NewWriter(clientConn))
 	defer clientConn.Close()
@@ -114,9 +131,12 @@ func handleConnection(clientConn net.Conn, pool *inmemory.Pool, circle *inmemory
 			} else {
 				query := input
 				log.Printf("writing to server %s %s", server.Addr, query)
+
+				start := time.Now()
 				serverrw.WriteString(query)
 				serverrw.Flush()
 				result, err := serverrw.ReadString('\n')
+				commandMetrics.Observe(strings.ToUpper(fields[0]), time.Since(start), err != nil)
 
 				log.Printf("got result: %s", string(result))
 				if err != nil {
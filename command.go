@@ -6,73 +6,20 @@ import (
 	"time"
 )
 
-// get fetches Item pointer from the data store.
-func (dataStore *DataStore) get(key string) (*Item, bool) {
-	value, ok := dataStore.values[key]
-	if ok {
-		return value, true
-	}
-	return nil, false
-}
-
-func (dataStore *DataStore) set(key string, value *Item) {
-	dataStore.values[key] = value
-}
-
-// remove item from the data store by the given key.
-// the item is also removed from cache
-func (dataStore *DataStore) remove(key string) error {
-	item, ok := dataStore.get(key)
-
-	if !ok {
-		return errNoItem
-	}
-
-	cacheEl := item.el
-
-	// break the link from the Item for element in the cache for safe removal
-	item.el = nil
-	dataStore.cache.Remove(cacheEl)
-	delete(dataStore.values, key)
-	return nil
-}
+// The commands parse the string arguments of the client, call the typed
+// methods of the data store and convert the result to the string reply.
 
 // Get command retrieves string value by given key from the data store.
 // If item was successfully read, it will be updated as the most recently used in cache.
 // Arguments are read from Args field of client object.
 func Get(client *Client) {
 
-	dataStore := client.ds
-
 	if len(client.args) != 1 {
 		client.err = errArgumentNumber
 		return
 	}
 
-	key := client.args[0]
-
-	dataStore.Lock()
-	defer dataStore.Unlock()
-
-	item, ok := dataStore.get(key)
-	if !ok {
-		client.err = errNoItem
-		return
-	}
-
-	// convert item's value to the string
-	result, ok := item.Value.(string)
-
-	if !ok {
-		client.err = errNotString
-		return
-
-	}
-
-	client.reply = result
-
-	// updating cache to set the current item as the most recently used
-	dataStore.cache.MoveToFront(item.el)
+	client.reply, client.err = client.ds.Get(client.args[0])
 }
 
 // Set command will set string value by given key and value in the data store.
@@ -80,8 +27,6 @@ func Get(client *Client) {
 // Arguments are read from Args field of client object.
 func Set(client *Client) {
 
-	dataStore := client.ds
-
 	if len(client.args) < 2 || len(client.args) > 3 {
 		client.err = errArgumentNumber
 		return
@@ -90,47 +35,28 @@ func Set(client *Client) {
 	key := client.args[0]
 	value := client.args[1]
 
-	// expire is the time to live in seconds
-	var expire int64
+	// use default expiration time, if ttl is not set by user
+	ttl := DefaultExpiration
 
 	// if ttl is set by user
 	if len(client.args) == 3 {
 
-		// parse ttl and check it for correctness
+		// parse ttl in seconds and check it for correctness
 		expire, err := strconv.ParseInt(client.args[2], 10, 64)
 		if err != nil {
 			client.err = errTTLFormat
 			return
 		}
 		if expire < 0 {
-			client.err = errTTLValue
+			client.err = ErrTTLValue
 			return
 		}
-	} else {
-		// use default expiration time
-		expire = defaultExpiration
-	}
-
-	if expire != 0 {
-		expire += time.Now().Unix()
+		ttl = time.Duration(expire) * time.Second
 	}
 
-	item := &Item{
-		Value: value,
-		el:    nil,
+	if client.err = client.ds.Set(key, value, ttl); client.err == nil {
+		client.reply = "OK"
 	}
-
-	dataStore.Lock()
-	defer dataStore.Unlock()
-
-	dataStore.set(key, item)
-	dataStore.ttlCommands <- expiration{"SET", key, expire}
-
-	// update the cache
-	el := dataStore.cache.PushFront(key)
-	item.el = el
-
-	client.reply = "OK"
 }
 
 // Size command return number of all keys in the data store.
@@ -141,13 +67,8 @@ func Size(client *Client) {
 		return
 	}
 
-	dataStore := client.ds
-
-	dataStore.RLock()
-	defer dataStore.RUnlock()
-
 	// convert int number of values to the string
-	client.reply = strconv.Itoa(len(dataStore.values))
+	client.reply = strconv.Itoa(client.ds.Size())
 }
 
 // Remove element from the data store by given key.
@@ -158,35 +79,16 @@ func Remove(client *Client) {
 		return
 	}
 
-	dataStore := client.ds
-	key := client.args[0]
-
-	dataStore.Lock()
-	defer dataStore.Unlock()
-
-	dataStore.ttlCommands <- expiration{"DELETE", key, 0}
-	err := dataStore.remove(key)
-
-	if err == nil {
+	if client.err = client.ds.Remove(client.args[0]); client.err == nil {
 		client.reply = "OK"
-	} else {
-		client.err = err
 	}
 }
 
 // RemoveBatch of keys from the datastore.
 func RemoveBatch(client *Client) {
-	dataStore := client.ds
-
-	dataStore.Lock()
-	defer dataStore.Unlock()
-
-	for _, key := range client.args {
-		dataStore.ttlCommands <- expiration{"DELETE", key, 0}
-		dataStore.remove(key)
+	if client.err = client.ds.RemoveBatch(client.args...); client.err == nil {
+		client.reply = "OK"
 	}
-
-	client.reply = "OK"
 }
 
 // Keys which are currently in the data store.
@@ -198,26 +100,12 @@ func Keys(client *Client) {
 		return
 	}
 
-	dataStore := client.ds
-
-	dataStore.RLock()
-	defer dataStore.RUnlock()
-
-	data := &dataStore.values
-	res := make([]string, len(*data))
-
-	// fill list of strings with current keys
-	i := 0
-	for k := range *data {
-		res[i] = k
-		i++
-	}
-
 	// convert list of strings to the one string
-	client.reply = strings.Join(res, " ")
+	client.reply = strings.Join(client.ds.Keys(), " ")
 }
 
 // TTL updates ttl value of the item.
+// Updating ttl of the missing item is not an error.
 func TTL(client *Client) {
 
 	if len(client.args) != 2 {
@@ -235,21 +123,15 @@ func TTL(client *Client) {
 	}
 
 	if expire < 0 {
-		client.err = errTTLValue
+		client.err = ErrTTLValue
 		return
 	}
 
-	// set absolute time for the expiration
-	if expire != 0 {
-		expire += time.Now().Unix()
+	err = client.ds.Expire(key, time.Duration(expire)*time.Second)
+	if err != nil && err != ErrNoItem {
+		client.err = err
+		return
 	}
-
-	dataStore := client.ds
-
-	dataStore.ttlCommands <- expiration{"SET", key, expire}
-
-	// set the expiration time
-	//item.Expiration = time.Now().Unix() + expire
 	client.reply = "OK"
 }
 
@@ -265,8 +147,6 @@ func LSet(client *Client) {
 		return
 	}
 
-	key := client.args[0]
-
 	// get the index of the list and check it for correctness
 	index, err := strconv.Atoi(client.args[1])
 	if err != nil {
@@ -274,36 +154,9 @@ func LSet(client *Client) {
 		return
 	}
 
-	value := client.args[2]
-
-	dataStore := client.ds
-
-	dataStore.Lock()
-	defer dataStore.Unlock()
-
-	item, ok := dataStore.get(key)
-	if !ok {
-		client.err = errNoItem
-		return
-	}
-
-	// convert the item to the list type
-	list, ok := item.Value.([]string)
-	if !ok {
-		client.err = errNotList
-		return
-	}
-	if index >= len(list) || index < 0 {
-		client.err = errIndexRange
-		return
+	if client.err = client.ds.LSet(client.args[0], index, client.args[2]); client.err == nil {
+		client.reply = "OK"
 	}
-
-	list[index] = value
-
-	// update the cache
-	dataStore.cache.MoveToFront(item.el)
-
-	client.reply = "OK"
 }
 
 // LPush is used to push value in the list.
@@ -316,43 +169,9 @@ func LPush(client *Client) {
 		return
 	}
 
-	key := client.args[0]
-	value := client.args[1]
-
-	dataStore := client.ds
-
-	dataStore.Lock()
-	defer dataStore.Unlock()
-
-	item, ok := dataStore.get(key)
-
-	// create new list, if there is none
-	if !ok {
-		newItem := &Item{
-			Value: []string{value},
-			//Expiration: time.Now().Unix() + defaultExpiration,
-			el: nil,
-		}
-		dataStore.set(key, newItem)
-		el := dataStore.cache.PushFront(key)
-		newItem.el = el
-
+	if client.err = client.ds.LPush(client.args[0], client.args[1]); client.err == nil {
 		client.reply = "OK"
-		return
 	}
-
-	// convert existing item to the list type
-	list, ok := item.Value.([]string)
-	if !ok {
-		client.err = errNotList
-		return
-	}
-	item.Value = append(list, value)
-
-	// update the cache
-	dataStore.cache.MoveToFront(item.el)
-
-	client.reply = "OK"
 }
 
 // LGet returns value from the list item by given key.
@@ -366,134 +185,215 @@ func LGet(client *Client) {
 		return
 	}
 
-	key := client.args[0]
 	index, err := strconv.Atoi(client.args[1])
 	if err != nil {
 		client.err = errIndexFormat
 		return
 	}
 
-	dataStore := client.ds
+	client.reply, client.err = client.ds.LGet(client.args[0], index)
+}
 
-	dataStore.Lock()
-	defer dataStore.Unlock()
+// HSet updates or creates the value in the hash item in the data store.
+// If there is no hash item, it will be created.
+// Arguments are read from Args field of client object.
+// Hash item will be updated as the most recently used in the cache.
+func HSet(client *Client) {
 
-	item, ok := dataStore.get(key)
-	if !ok {
-		client.err = errNoItem
+	if len(client.args) != 3 {
+		client.err = errArgumentNumber
 		return
 	}
 
-	// convert existing item to the list type
-	list, ok := item.Value.([]string)
-	if !ok {
-		client.err = errNotList
-		return
-	}
-	if index >= len(list) || index < 0 {
-		client.err = errIndexRange
-		return
+	if client.err = client.ds.HSet(client.args[0], client.args[1], client.args[2]); client.err == nil {
+		client.reply = "OK"
 	}
-
-	// update the cache
-	dataStore.cache.MoveToFront(item.el)
-	client.reply = list[index]
 }
 
-// HSet updates or creates the value in the hash item in the data store.
-// If there is no hash item, it will be created.
+// HGet retrieves value from hash by given key.
+// If there is no such item, or hash doesn't exist - error will be thrown.
 // Arguments are read from Args field of client object.
 // Hash item will be updated as the most recently used in the cache.
-func HSet(client *Client) {
+func HGet(client *Client) {
 
-	if len(client.args) != 3 {
+	if len(client.args) != 2 {
 		client.err = errArgumentNumber
 		return
 	}
 
-	key := client.args[0]
-	hashKey := client.args[1]
-	value := client.args[2]
+	client.reply, client.err = client.ds.HGet(client.args[0], client.args[1])
+}
+
+// Object command allows to inspect the internals of the item.
+// Supported subcommands:
+//   - FREQ key returns the access frequency counter of the item,
+//     it's available only with LFU eviction policy.
+func Object(client *Client) {
+
+	if len(client.args) != 2 {
+		client.err = errArgumentNumber
+		return
+	}
 
-	dataStore := client.ds
+	if strings.ToUpper(client.args[0]) != "FREQ" {
+		client.err = errNoSubcommand
+		return
+	}
 
-	dataStore.Lock()
-	defer dataStore.Unlock()
+	freq, err := client.ds.Freq(client.args[1])
+	if err != nil {
+		client.err = err
+		return
+	}
+	client.reply = strconv.Itoa(freq)
+}
 
-	item, ok := dataStore.get(key)
+// Info command returns the report about the data store.
+// The optional argument selects the section, e.g. INFO memory.
+// The lines of the report are separated by spaces to fit the single reply line.
+func Info(client *Client) {
 
-	// create new hash if it doesn't exist
-	if !ok {
-		newItem := &Item{
-			Value: map[string]string{
-				hashKey: value,
-			},
-			//Expiration: time.Now().Unix() + defaultExpiration,
-			el: nil,
-		}
+	if len(client.args) > 1 {
+		client.err = errArgumentNumber
+		return
+	}
 
-		// set the value to new hash
-		dataStore.set(key, newItem)
+	section := ""
+	if len(client.args) == 1 {
+		section = client.args[0]
+	}
 
-		// add new hash to the cache
-		el := dataStore.cache.PushFront(key)
-		newItem.el = el
-		client.reply = "OK"
+	info, err := client.ds.Info(section)
+	if err != nil {
+		client.err = err
 		return
 	}
+	client.reply = strings.Replace(info, "\n", " ", -1)
+}
+
+// Config command manages the data store at runtime.
+// Supported subcommands:
+//   - RESETSTAT resets the statistics reported by INFO.
+func Config(client *Client) {
 
-	// convert existing item to the map type
-	hash, ok := item.Value.(map[string]string)
-	if !ok {
-		client.err = errNotHash
+	if len(client.args) != 1 {
+		client.err = errArgumentNumber
 		return
 	}
 
-	hash[hashKey] = value
-	dataStore.cache.MoveToFront(item.el)
+	if strings.ToUpper(client.args[0]) != "RESETSTAT" {
+		client.err = errNoSubcommand
+		return
+	}
 
+	client.ds.ResetStats()
 	client.reply = "OK"
 }
 
-// HGet retrieves value from hash by given key.
-// If there is no such item, or hash doesn't exist - error will be thrown.
-// Arguments are read from Args field of client object.
-// Hash item will be updated as the most recently used in the cache.
-func HGet(client *Client) {
+// SlowLog command inspects the commands which ran longer than the threshold.
+// Supported subcommands:
+//   - GET [count] returns the latest entries, 10 by default, separated by ";".
+//     Every entry is "id unix_time duration_us client command args".
+//   - LEN returns the number of the entries.
+//   - RESET removes all the entries.
+func SlowLog(client *Client) {
 
-	if len(client.args) != 2 {
+	if len(client.args) < 1 || len(client.args) > 2 {
 		client.err = errArgumentNumber
 		return
 	}
 
-	key := client.args[0]
-	hashKey := client.args[1]
+	subcommand := strings.ToUpper(client.args[0])
+	if subcommand != "GET" && len(client.args) != 1 {
+		client.err = errArgumentNumber
+		return
+	}
 
-	dataStore := client.ds
+	switch subcommand {
+	case "GET":
+		count := 10
+		if len(client.args) == 2 {
+			var err error
+			if count, err = strconv.Atoi(client.args[1]); err != nil {
+				client.err = errCountFormat
+				return
+			}
+		}
 
-	dataStore.Lock()
-	defer dataStore.Unlock()
+		var entries []string
+		for _, entry := range client.ds.SlowLog(count) {
+			entries = append(entries, entry.String())
+		}
+		client.reply = strings.Join(entries, "; ")
+	case "LEN":
+		client.reply = strconv.Itoa(client.ds.SlowLogLen())
+	case "RESET":
+		client.ds.ResetSlowLog()
+		client.reply = "OK"
+	default:
+		client.err = errNoSubcommand
+	}
+}
 
-	item, ok := dataStore.get(key)
+// Save command writes the backup and replies when it's complete.
+func Save(client *Client) {
 
-	if !ok {
-		client.err = errNoItem
+	if len(client.args) != 0 {
+		client.err = errArgumentNumber
 		return
 	}
 
-	// convert existing item to the hash type
-	hash, ok := item.Value.(map[string]string)
-	if !ok {
-		client.err = errNotHash
+	if err := client.ds.Save(); err != nil {
+		client.err = err
 		return
 	}
-	result, ok := hash[hashKey]
-	if !ok {
-		client.err = errNoKeyHash
+	client.reply = "OK"
+}
+
+// BackgroundSave command starts writing the backup and replies immediately.
+// The completion is checked with LASTSAVE or INFO persistence.
+func BackgroundSave(client *Client) {
+
+	if len(client.args) != 0 {
+		client.err = errArgumentNumber
 		return
 	}
 
-	// update the cache
-	dataStore.cache.MoveToFront(item.el)
-	client.reply = result
+	if err := client.ds.BackgroundSave(); err != nil {
+		client.err = err
+		return
+	}
+	client.reply = "Background saving started"
+}
+
+// LastSave command returns the unix time of the last successful backup,
+// 0 if there was none.
+func LastSave(client *Client) {
+
+	if len(client.args) != 0 {
+		client.err = errArgumentNumber
+		return
+	}
+
+	var unix int64
+	if lastSave := client.ds.LastSave(); !lastSave.IsZero() {
+		unix = lastSave.Unix()
+	}
+	client.reply = strconv.FormatInt(unix, 10)
+}
+
+// BackgroundRewriteAppendOnly command starts the rewrite of the append-only
+// file and replies immediately. The completion is checked with INFO persistence.
+func BackgroundRewriteAppendOnly(client *Client) {
+
+	if len(client.args) != 0 {
+		client.err = errArgumentNumber
+		return
+	}
+
+	if err := client.ds.BackgroundRewriteAppendOnly(); err != nil {
+		client.err = err
+		return
+	}
+	client.reply = "Background append only file rewriting started"
 }
@@ -1,6 +1,7 @@
 package inmemory
 
 import (
+	"strconv"
 	"testing"
 )
 
@@ -102,3 +103,46 @@ func BenchmarkHGet(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+// benchmarkParallel runs the command on the data store with given policy and
+// number of shards from all the available goroutines. Each goroutine uses its own key.
+func benchmarkParallel(b *testing.B, policy string, shards int, command string, args ...string) {
+	dataStore, err := NewWithOptions(WithEvictionPolicy(policy), WithShards(shards), WithWorkers(NoWorkers))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	keys := make(chan string, 1024)
+	for i := 0; i < cap(keys); i++ {
+		key := "key" + strconv.Itoa(i)
+		NewClient(dataStore).Exec("set", []string{key, "15"})
+		keys <- key
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		client := NewClient(dataStore)
+		cmdArgs := append([]string{<-keys}, args...)
+		for pb.Next() {
+			client.Exec(command, cmdArgs)
+		}
+	})
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	for _, policy := range []string{"allkeys-lru", "allkeys-approx-lru"} {
+		for _, shards := range []int{1, defaultShards} {
+			b.Run(policy+"/shards="+strconv.Itoa(shards), func(b *testing.B) {
+				benchmarkParallel(b, policy, shards, "get")
+			})
+		}
+	}
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	for _, shards := range []int{1, defaultShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			benchmarkParallel(b, defaultPolicy, shards, "set", "15")
+		})
+	}
+}
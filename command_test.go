@@ -1,8 +1,6 @@
 package inmemory
 
 import (
-	"io/ioutil"
-	"log"
 	"strconv"
 	"testing"
 )
@@ -28,12 +26,12 @@ var (
 			{"1 argument", []string{"key"}, "", errArgumentNumber},
 			{"4 arguments", []string{"key", "value", "42", "huh?"}, "", errArgumentNumber},
 			{"wrong TTL format", []string{"key", "value", "fifteen"}, "", errTTLFormat},
-			{"TTL less than 0", []string{"key", "value", "-42"}, "", errTTLValue},
+			{"TTL less than 0", []string{"key", "value", "-42"}, "", ErrTTLValue},
 		},
 		"GET": {
 			{"existing value", []string{"x"}, "15", nil},
 			{"get same value again", []string{"x"}, "15", nil},
-			{"nonexistent value", []string{"y"}, "", errNoItem},
+			{"nonexistent value", []string{"y"}, "", ErrNoItem},
 			{"0 arguments", []string{}, "", errArgumentNumber},
 			{"2 arguments", []string{"item1", "item2"}, "", errArgumentNumber},
 		},
@@ -43,7 +41,7 @@ var (
 		},
 		"REMOVE": {
 			{"correct usage", []string{"key2"}, "OK", nil},
-			{"delete same key again", []string{"key2"}, "", errNoItem},
+			{"delete same key again", []string{"key2"}, "", ErrNoItem},
 			{"0 arguments", []string{}, "", errArgumentNumber},
 			{"2 arguments", []string{"x", "y"}, "", errArgumentNumber},
 		},
@@ -56,29 +54,29 @@ var (
 			{"0 arguments", []string{}, "", errArgumentNumber},
 			{"1 argument", []string{"x"}, "", errArgumentNumber},
 			{"ttl not a number", []string{"x", "y"}, "", errTTLFormat},
-			{"ttl is less than 0", []string{"x", "-1"}, "", errTTLValue},
+			{"ttl is less than 0", []string{"x", "-1"}, "", ErrTTLValue},
 		},
 		"LSET": {
 			{"correct usage", []string{"list", "2", "10"}, "OK", nil},
 			{"wrong arguments number", []string{"list", "2"}, "", errArgumentNumber},
 			{"wrong index format", []string{"list", "index", "10"}, "", errIndexFormat},
-			{"index out of range", []string{"list", "10", "10"}, "", errIndexRange},
-			{"set not to list", []string{"x", "0", "0"}, "", errNotList},
+			{"index out of range", []string{"list", "10", "10"}, "", ErrIndexRange},
+			{"set not to list", []string{"x", "0", "0"}, "", ErrNotList},
 		},
 		"LPUSH": {
 			{"correct usage", []string{"list", "value"}, "OK", nil},
 			{"push to the same", []string{"list", "value1"}, "OK", nil},
-			{"try to push to string", []string{"x", "value"}, "", errNotList},
+			{"try to push to string", []string{"x", "value"}, "", ErrNotList},
 			{"0 arguments", []string{}, "", errArgumentNumber},
 			{"1 argument", []string{}, "", errArgumentNumber},
 			{"4 arguments", []string{}, "", errArgumentNumber},
 		},
 		"LGET": {
 			{"correct usage", []string{"list", "0"}, "value", nil},
-			{"get outside of range", []string{"list", "99"}, "", errIndexRange},
-			{"get not from list", []string{"x", "0"}, "", errNotList},
+			{"get outside of range", []string{"list", "99"}, "", ErrIndexRange},
+			{"get not from list", []string{"x", "0"}, "", ErrNotList},
 			{"wrong index format", []string{"list", "index"}, "", errIndexFormat},
-			{"get from unexisting list", []string{"list1", "0"}, "", errNoItem},
+			{"get from unexisting list", []string{"list1", "0"}, "", ErrNoItem},
 			{"0 arguments", []string{}, "", errArgumentNumber},
 			{"1 argument", []string{}, "", errArgumentNumber},
 			{"3 arguments", []string{"list", "3", "something"}, "", errArgumentNumber},
@@ -87,21 +85,22 @@ var (
 			{"correct usage", []string{"hash", "x", "value"}, "OK", nil},
 			{"wrong arguments number", []string{"hash", "key"}, "", errArgumentNumber},
 			{"insert in the same map", []string{"hash", "y", "value"}, "OK", nil},
-			{"set on existing object", []string{"x", "key", "value"}, "", errNotHash},
+			{"set on existing object", []string{"x", "key", "value"}, "", ErrNotHash},
 		},
 		"HGET": {
 			{"correct usage", []string{"hash", "key"}, "value", nil},
 			{"wrong arguments number", []string{"hash", "key", "value"}, "", errArgumentNumber},
-			{"get from not hash", []string{"x", "key"}, "", errNotHash},
-			{"get nonexistent item", []string{"hash", "key1"}, "", errNoKeyHash},
-			{"get from nonexistent hash", []string{"hash1", "key"}, "", errNoItem},
+			{"get from not hash", []string{"x", "key"}, "", ErrNotHash},
+			{"get nonexistent item", []string{"hash", "key1"}, "", ErrNoKeyHash},
+			{"get from nonexistent hash", []string{"hash1", "key"}, "", ErrNoItem},
 		},
 	}
 )
 
 // setup new data store and create client object for it
+// Only ttld is started, so the tests don't write backups and nothing is evicted.
 func setupTestClient() *Client {
-	dataStore := New()
+	dataStore, _ := NewWithOptions(WithWorkers(TTLWorker))
 
 	client := &Client{
 		ds:    dataStore,
@@ -229,10 +228,6 @@ func TestTTL(t *testing.T) {
 	testData(client, 4)
 
 	runner(t, "TTL", client)
-
-	log.SetOutput(ioutil.Discard)
-	// should handle wrong ttl command
-	client.ds.ttlCommands <- expiration{"WRONG COMMAND", "KEY", 15}
 }
 
 func TestLSet(t *testing.T) {
@@ -246,8 +241,8 @@ func TestLSet(t *testing.T) {
 	runner(t, "LSET", client)
 
 	client.Exec("LSET", []string{"doesntexist", "0", "14"})
-	if client.err != errNoItem {
-		t.Errorf("Set key non-existent list should give %#v, got: %#v", errNoItem, client.err)
+	if client.err != ErrNoItem {
+		t.Errorf("Set key non-existent list should give %#v, got: %#v", ErrNoItem, client.err)
 	}
 }
 
@@ -0,0 +1,367 @@
+// Command dump inspects and converts the backups of the data server
+// without starting it.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pasiukevich/inmemory"
+)
+
+const usage = `Usage: dump [options] <command> <arguments>
+
+Commands:
+  list <backup>             list the keys with their types, sizes and ttls
+  get <backup> <key>        print the value of the key
+  diff <backup> <backup>    list the added (+), removed (-) and changed (~) keys
+  export <backup>           write the entries as JSON Lines to stdout
+  import <jsonl> <backup>   write the backup from the JSON Lines file, - for stdin
+
+Options:
+`
+
+var errUsage = errors.New("wrong arguments, see -h")
+
+// entry is the line of the JSON Lines file. The value is a string,
+// an array of strings or an object of strings depending on the type.
+type entry struct {
+	Key    string          `json:"key"`
+	Type   string          `json:"type"`
+	Value  json.RawMessage `json:"value"`
+	Expire int64           `json:"expire,omitempty"`
+	Access int64           `json:"access,omitempty"`
+	LFU    uint32          `json:"lfu,omitempty"`
+}
+
+// filter selects the keys by the glob pattern, e.g. "user:*".
+type filter string
+
+func (pattern filter) match(key string) bool {
+	matched, _ := path.Match(string(pattern), key)
+	return matched
+}
+
+func main() {
+
+	matchPtr := flag.String("match", "*", "Glob pattern of the keys to list, diff or export, e.g. \"user:*\".")
+	keyPtr := flag.String("encryption-key", "", "Path to the file with the base64 encoded key of the encrypted backups, also used to encrypt the imported backup. Taken from "+inmemory.EncryptionKeyEnv+" if empty.")
+	previousKeysPtr := flag.String("previous-encryption-keys", "", "Comma separated paths to the files with the rotated keys. Taken from "+inmemory.PreviousEncryptionKeysEnv+" if empty.")
+	compressPtr := flag.Bool("compress", false, "Compress the imported backup.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	key, previous, err := inmemory.LoadEncryptionKeys(*keyPtr, *previousKeysPtr)
+	if err != nil {
+		log.Fatal("Error loading encryption key ", err)
+	}
+	var keys []*inmemory.EncryptionKey
+	if key != nil {
+		keys = append([]*inmemory.EncryptionKey{key}, previous...)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	pattern := filter(*matchPtr)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	switch {
+	case args[0] == "list" && len(args) == 2:
+		err = list(out, args[1], pattern, keys)
+	case args[0] == "get" && len(args) == 3:
+		err = get(out, args[1], args[2], keys)
+	case args[0] == "diff" && len(args) == 3:
+		var changed bool
+		if changed, err = diff(out, args[1], args[2], pattern, keys); err == nil && changed {
+			// the exit code tells if the backups differ like diff(1)
+			out.Flush()
+			os.Exit(1)
+		}
+	case args[0] == "export" && len(args) == 2:
+		err = export(out, args[1], pattern, keys)
+	case args[0] == "import" && len(args) == 3:
+		err = importLines(args[1], args[2], *compressPtr, key)
+	default:
+		err = errUsage
+	}
+
+	if err != nil {
+		out.Flush()
+		log.Fatal(err)
+	}
+}
+
+// read calls fn for every entry of the backup.
+func read(backup string, keys []*inmemory.EncryptionKey, fn func(entry inmemory.SnapshotEntry) error) error {
+	file, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := inmemory.NewSnapshotReader(bufio.NewReader(file), keys...)
+	if err != nil {
+		return fmt.Errorf("%s: %v", backup, err)
+	}
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", backup, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// load reads the matching entries of the backup by their keys.
+func load(backup string, pattern filter, keys []*inmemory.EncryptionKey) (map[string]inmemory.SnapshotEntry, error) {
+	entries := make(map[string]inmemory.SnapshotEntry)
+	err := read(backup, keys, func(entry inmemory.SnapshotEntry) error {
+		if pattern.match(entry.Key) {
+			entries[entry.Key] = entry
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// list prints "key type size ttl" lines. The size is the length of the
+// string or the number of the list values or hash fields. The ttl is
+// in seconds from now, -1 for the keys without expiration.
+func list(out io.Writer, backup string, pattern filter, keys []*inmemory.EncryptionKey) error {
+	now := time.Now().Unix()
+	return read(backup, keys, func(entry inmemory.SnapshotEntry) error {
+		if !pattern.match(entry.Key) {
+			return nil
+		}
+
+		ttl := "-1"
+		switch {
+		case entry.Expire != 0 && entry.Expire <= now:
+			ttl = "expired"
+		case entry.Expire != 0:
+			ttl = fmt.Sprint(entry.Expire - now)
+		}
+		_, err := fmt.Fprintf(out, "%q %s %d %s\n", entry.Key, typeOf(entry.Value), sizeOf(entry.Value), ttl)
+		return err
+	})
+}
+
+// get prints the value of the key as JSON.
+func get(out io.Writer, backup, key string, keys []*inmemory.EncryptionKey) error {
+	found := false
+	err := read(backup, keys, func(entry inmemory.SnapshotEntry) error {
+		if entry.Key != key {
+			return nil
+		}
+		found = true
+		value, err := json.MarshalIndent(entry.Value, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", value)
+		return err
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("no key %q in %s", key, backup)
+	}
+	return err
+}
+
+// diff prints the keys added, removed or changed in the new backup.
+// The changed expiration counts as the change, the usage of the key doesn't.
+func diff(out io.Writer, oldBackup, newBackup string, pattern filter, keys []*inmemory.EncryptionKey) (bool, error) {
+	oldEntries, err := load(oldBackup, pattern, keys)
+	if err != nil {
+		return false, err
+	}
+	newEntries, err := load(newBackup, pattern, keys)
+	if err != nil {
+		return false, err
+	}
+
+	var lines []string
+	for key, entry := range newEntries {
+		old, ok := oldEntries[key]
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("+ %q", key))
+		case old.Expire != entry.Expire || !reflect.DeepEqual(old.Value, entry.Value):
+			lines = append(lines, fmt.Sprintf("~ %q", key))
+		}
+	}
+	for key := range oldEntries {
+		if _, ok := newEntries[key]; !ok {
+			lines = append(lines, fmt.Sprintf("- %q", key))
+		}
+	}
+
+	// sorted by the key, not by the mark
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return false, err
+		}
+	}
+	return len(lines) > 0, nil
+}
+
+// export writes the matching entries as JSON Lines.
+func export(out io.Writer, backup string, pattern filter, keys []*inmemory.EncryptionKey) error {
+	encoder := json.NewEncoder(out)
+	return read(backup, keys, func(e inmemory.SnapshotEntry) error {
+		if !pattern.match(e.Key) {
+			return nil
+		}
+
+		value, err := json.Marshal(e.Value)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode(entry{
+			Key:    e.Key,
+			Type:   typeOf(e.Value),
+			Value:  value,
+			Expire: e.Expire,
+			Access: e.Access,
+			LFU:    e.LFU,
+		})
+	})
+}
+
+// importLines writes the backup with the entries of the JSON Lines file.
+// The backup is written under the temporary name and renamed when it's
+// complete, so the existing backup isn't damaged by the invalid input.
+func importLines(input, backup string, compress bool, key *inmemory.EncryptionKey) error {
+	in := os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	file, err := os.Create(backup + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		os.Remove(backup + ".tmp")
+	}()
+
+	out := bufio.NewWriter(file)
+	writer, err := inmemory.NewSnapshotWriter(out, time.Now(), compress, key)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<30)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		e, err := decodeLine(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := writer.Write(e); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(backup+".tmp", backup)
+}
+
+// decodeLine decodes the entry of the JSON Lines file.
+func decodeLine(line []byte) (inmemory.SnapshotEntry, error) {
+	var e entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return inmemory.SnapshotEntry{}, err
+	}
+
+	decoded := inmemory.SnapshotEntry{Key: e.Key, Expire: e.Expire, Access: e.Access, LFU: e.LFU}
+	var err error
+	switch e.Type {
+	case "string":
+		var value string
+		err = json.Unmarshal(e.Value, &value)
+		decoded.Value = value
+	case "list":
+		var value []string
+		err = json.Unmarshal(e.Value, &value)
+		decoded.Value = value
+	case "hash":
+		var value map[string]string
+		err = json.Unmarshal(e.Value, &value)
+		decoded.Value = value
+	default:
+		err = fmt.Errorf("unknown type %q", e.Type)
+	}
+	return decoded, err
+}
+
+// typeOf returns the name of the type of the value.
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case []string:
+		return "list"
+	case map[string]string:
+		return "hash"
+	}
+	return "unknown"
+}
+
+// sizeOf returns the length of the string or the number of the elements.
+func sizeOf(value interface{}) int {
+	switch value := value.(type) {
+	case string:
+		return len(value)
+	case []string:
+		return len(value)
+	case map[string]string:
+		return len(value)
+	}
+	return 0
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pasiukevich/inmemory"
+)
+
+// testBackup writes the backup of the entries to the directory.
+func testBackup(t *testing.T, dir, name string, entries ...inmemory.SnapshotEntry) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer, err := inmemory.NewSnapshotWriter(file, time.Now(), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := writer.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	entries := []inmemory.SnapshotEntry{
+		{Key: "string", Value: "value", Expire: time.Now().Add(time.Hour).Unix()},
+		{Key: "list", Value: []string{"a", "b"}, Access: 1700000000},
+		{Key: "hash", Value: map[string]string{"field": "value"}, LFU: 5},
+	}
+	backup := testBackup(t, dir, "old.snap", entries...)
+
+	var exported bytes.Buffer
+	if err := export(&exported, backup, "*", nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := filepath.Join(dir, "backup.jsonl")
+	if err := os.WriteFile(lines, exported.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := filepath.Join(dir, "new.snap")
+	if err := importLines(lines, imported, true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var restored []inmemory.SnapshotEntry
+	if err := read(imported, nil, func(entry inmemory.SnapshotEntry) error {
+		restored = append(restored, entry)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, entries) {
+		t.Errorf("Expected %v, got %v", entries, restored)
+	}
+
+	// the filtered export
+	exported.Reset()
+	if err := export(&exported, backup, "l*", nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"key":"list","type":"list","value":["a","b"],"access":1700000000}` + "\n"; exported.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, exported.String())
+	}
+
+	if err := os.WriteFile(lines, []byte(`{"key":"set","type":"set","value":["a"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := importLines(lines, imported, false, nil); err == nil {
+		t.Error("Expected unknown type to fail")
+	}
+	if _, err := os.Stat(imported); err != nil {
+		t.Errorf("Expected the existing backup to be kept, got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	backup := testBackup(t, t.TempDir(), "backup.snap",
+		inmemory.SnapshotEntry{Key: "string", Value: "value", Expire: time.Now().Add(time.Hour).Unix()},
+		inmemory.SnapshotEntry{Key: "expired", Value: "value", Expire: 1700000000},
+		inmemory.SnapshotEntry{Key: "list", Value: []string{"a", "b"}},
+		inmemory.SnapshotEntry{Key: "hash", Value: map[string]string{"a": "1", "b": "2", "c": "3"}},
+	)
+
+	var out bytes.Buffer
+	if err := list(&out, backup, "*", nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 keys, got %q", out.String())
+	}
+
+	// the ttl is counted from now
+	fields := strings.Fields(lines[0])
+	if ttl, err := strconv.Atoi(fields[3]); fields[0] != `"string"` || fields[1] != "string" || fields[2] != "5" ||
+		err != nil || ttl < 3500 || ttl > 3600 {
+		t.Errorf("Unexpected string key %q", lines[0])
+	}
+	expected := []string{`"expired" string 5 expired`, `"list" list 2 -1`, `"hash" hash 3 -1`}
+	if !reflect.DeepEqual(lines[1:], expected) {
+		t.Errorf("Expected %q, got %q", expected, lines[1:])
+	}
+
+	out.Reset()
+	if err := list(&out, backup, "l*", nil); err != nil || out.String() != `"list" list 2 -1`+"\n" {
+		t.Errorf("Expected only the list key, got %q, %v", out.String(), err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	backup := testBackup(t, t.TempDir(), "backup.snap",
+		inmemory.SnapshotEntry{Key: "string", Value: "value"},
+		inmemory.SnapshotEntry{Key: "hash", Value: map[string]string{"a": "1"}},
+	)
+
+	var out bytes.Buffer
+	if err := get(&out, backup, "hash", nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{\n  \"a\": \"1\"\n}\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	out.Reset()
+	if err := get(&out, backup, "string", nil); err != nil || out.String() != "\"value\"\n" {
+		t.Errorf("Expected the string value, got %q, %v", out.String(), err)
+	}
+
+	out.Reset()
+	if err := get(&out, backup, "missing", nil); err == nil || out.Len() != 0 {
+		t.Errorf("Expected the missing key to fail, got %q, %v", out.String(), err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldBackup := testBackup(t, dir, "old.snap",
+		inmemory.SnapshotEntry{Key: "changed", Value: "1"},
+		inmemory.SnapshotEntry{Key: "expire", Value: "1"},
+		inmemory.SnapshotEntry{Key: "removed", Value: []string{"a"}},
+		inmemory.SnapshotEntry{Key: "same", Value: map[string]string{"a": "1"}, Access: 1},
+	)
+	newBackup := testBackup(t, dir, "new.snap",
+		inmemory.SnapshotEntry{Key: "added", Value: "1"},
+		inmemory.SnapshotEntry{Key: "changed", Value: "2"},
+		inmemory.SnapshotEntry{Key: "expire", Value: "1", Expire: 1700000000},
+		inmemory.SnapshotEntry{Key: "same", Value: map[string]string{"a": "1"}, Access: 2},
+	)
+
+	var out bytes.Buffer
+	changed, err := diff(&out, oldBackup, newBackup, "*", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `+ "added"
+~ "changed"
+~ "expire"
+- "removed"
+`
+	if !changed || out.String() != expected {
+		t.Errorf("Expected %q, got %v, %q", expected, changed, out.String())
+	}
+
+	out.Reset()
+	if changed, _ := diff(&out, oldBackup, newBackup, "same", nil); changed || out.Len() != 0 {
+		t.Errorf("Expected no changes, got %q", out.String())
+	}
+}
+
+func TestFilter(t *testing.T) {
+	cases := []struct {
+		pattern filter
+		key     string
+		matched bool
+	}{
+		{"*", "user:1", true},
+		{"user:*", "user:1", true},
+		{"user:*", "session:1", false},
+		{"user:?", "user:10", false},
+		{"user:[0-9]", "user:1", true},
+		{"[", "[", false},
+	}
+	for _, c := range cases {
+		if matched := c.pattern.match(c.key); matched != c.matched {
+			t.Errorf("%q matching %q: expected %v, got %v", c.pattern, c.key, c.matched, matched)
+		}
+	}
+}
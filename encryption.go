@@ -0,0 +1,224 @@
+package inmemory
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// Flags of the transformations applied to the payloads of the snapshot
+// sections and the append-only file frames.
+const (
+	flagCompressed = 1 << iota
+	flagEncrypted
+
+	knownFlags = flagCompressed | flagEncrypted
+)
+
+// length of the key id stored with the encrypted payload
+const keyIDSize = 8
+
+var (
+	errKeySize    = errors.New("encryption key should be 16, 24 or 32 bytes")
+	errKeyFormat  = errors.New("encryption key should be base64 encoded")
+	errUnknownKey = errors.New("data is encrypted with unknown key")
+	errDecryption = errors.New("data can't be decrypted")
+	errGzip       = errors.New("data can't be decompressed")
+)
+
+// EncryptionKey is the AES key encrypting the snapshots and the append-only
+// file with AES-GCM. The encrypted data holds the id of the key, so the data
+// encrypted with the previous keys can be decrypted after the key rotation.
+type EncryptionKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+// NewEncryptionKey creates the key for AES-128, AES-192 or AES-256
+// depending on its length.
+func NewEncryptionKey(key []byte) (*EncryptionKey, error) {
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, errKeySize
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey := &EncryptionKey{aead: aead}
+	hash := sha256.Sum256(key)
+	copy(encryptionKey.id[:], hash[:])
+	return encryptionKey, nil
+}
+
+// ParseEncryptionKey creates the key from its base64 encoding,
+// e.g. generated with "openssl rand -base64 32".
+func ParseEncryptionKey(encoded string) (*EncryptionKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errKeyFormat
+	}
+	return NewEncryptionKey(key)
+}
+
+// LoadEncryptionKey reads the base64 encoded key from the file.
+func LoadEncryptionKey(path string) (*EncryptionKey, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEncryptionKey(string(encoded))
+}
+
+// The environment variables holding the base64 encoded keys, which are used
+// by LoadEncryptionKeys if the files are not given.
+const (
+	EncryptionKeyEnv          = "INMEMORY_ENCRYPTION_KEY"
+	PreviousEncryptionKeysEnv = "INMEMORY_PREVIOUS_ENCRYPTION_KEYS"
+)
+
+// LoadEncryptionKeys loads the current key from the file and the previous
+// keys from the comma separated files. The keys of EncryptionKeyEnv and
+// PreviousEncryptionKeysEnv, separated by commas as well, are used instead
+// of the files not given. The nil key means the encryption is disabled.
+func LoadEncryptionKeys(path, previousPaths string) (*EncryptionKey, []*EncryptionKey, error) {
+	current, err := loadKeys(path, EncryptionKeyEnv)
+	if err != nil || len(current) == 0 {
+		return nil, nil, err
+	}
+	previous, err := loadKeys(previousPaths, PreviousEncryptionKeysEnv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return current[0], previous, nil
+}
+
+// loadKeys loads the keys from the comma separated paths, or parses
+// the comma separated keys of the environment variable.
+func loadKeys(paths, env string) ([]*EncryptionKey, error) {
+	load := LoadEncryptionKey
+	list := paths
+	if list == "" {
+		load = ParseEncryptionKey
+		list = os.Getenv(env)
+	}
+	if list == "" {
+		return nil, nil
+	}
+
+	var keys []*EncryptionKey
+	for _, item := range strings.Split(list, ",") {
+		key, err := load(item)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// encodePayload compresses and encrypts the payload according to the flags.
+// The encrypted payload is the key id, the nonce and the sealed data.
+// additional is authenticated, but not encrypted.
+func encodePayload(payload []byte, flags uint16, key *EncryptionKey, additional []byte) ([]byte, error) {
+	if flags&flagCompressed != 0 {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		payload = buf.Bytes()
+	}
+
+	if flags&flagEncrypted != 0 {
+		nonceSize := key.aead.NonceSize()
+		sealed := make([]byte, keyIDSize+nonceSize, keyIDSize+nonceSize+len(payload)+key.aead.Overhead())
+		copy(sealed, key.id[:])
+		if _, err := io.ReadFull(rand.Reader, sealed[keyIDSize:]); err != nil {
+			return nil, err
+		}
+		payload = key.aead.Seal(sealed, sealed[keyIDSize:], payload, additional)
+	}
+	return payload, nil
+}
+
+// decodePayload decrypts and decompresses the payload encoded with
+// encodePayload. The key is looked up by the id stored in the payload.
+func decodePayload(payload []byte, flags uint16, keys []*EncryptionKey, additional []byte) ([]byte, error) {
+	if flags&flagEncrypted != 0 {
+		if len(payload) < keyIDSize {
+			return nil, errDecryption
+		}
+
+		var key *EncryptionKey
+		for _, k := range keys {
+			if bytes.Equal(k.id[:], payload[:keyIDSize]) {
+				key = k
+				break
+			}
+		}
+		if key == nil {
+			return nil, errUnknownKey
+		}
+
+		nonceSize := key.aead.NonceSize()
+		if len(payload) < keyIDSize+nonceSize {
+			return nil, errDecryption
+		}
+		nonce := payload[keyIDSize : keyIDSize+nonceSize]
+		var err error
+		if payload, err = key.aead.Open(nil, nonce, payload[keyIDSize+nonceSize:], additional); err != nil {
+			return nil, errDecryption
+		}
+	}
+
+	if flags&flagCompressed != 0 {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, errGzip
+		}
+		if payload, err = io.ReadAll(reader); err != nil {
+			return nil, errGzip
+		}
+	}
+	return payload, nil
+}
+
+// keyRing holds the key encrypting the new snapshots and append-only file
+// records and all the keys decrypting the existing ones.
+type keyRing struct {
+	current *EncryptionKey
+	all     []*EncryptionKey
+}
+
+// key returns the current key, nil if the encryption is disabled.
+func (ring *keyRing) key() *EncryptionKey {
+	if ring == nil {
+		return nil
+	}
+	return ring.current
+}
+
+// keys returns the keys decrypting the data.
+func (ring *keyRing) keys() []*EncryptionKey {
+	if ring == nil {
+		return nil
+	}
+	return ring.all
+}
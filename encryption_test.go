@@ -0,0 +1,230 @@
+package inmemory
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) *EncryptionKey {
+	t.Helper()
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseEncryptionKey(base64.StdEncoding.EncodeToString(raw) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	cases := []struct {
+		encoded  string
+		expected error
+	}{
+		{base64.StdEncoding.EncodeToString(make([]byte, 16)), nil},
+		{base64.StdEncoding.EncodeToString(make([]byte, 24)), nil},
+		{base64.StdEncoding.EncodeToString(make([]byte, 20)), errKeySize},
+		{"not base64!", errKeyFormat},
+	}
+	for _, c := range cases {
+		if _, err := ParseEncryptionKey(c.encoded); err != c.expected {
+			t.Errorf("%q: expected %v, got %v", c.encoded, c.expected, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))), 0600)
+	if _, err := LoadEncryptionKey(path); err != nil {
+		t.Errorf("Couldn't load the key: %v", err)
+	}
+}
+
+func TestLoadEncryptionKeys(t *testing.T) {
+	dir := t.TempDir()
+	paths := make([]string, 3)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprint("key", i))
+		os.WriteFile(paths[i], []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i)}, 32))), 0600)
+	}
+
+	key, previous, err := LoadEncryptionKeys(paths[0], paths[1]+","+paths[2])
+	if err != nil || key == nil || len(previous) != 2 || previous[1].id == key.id {
+		t.Errorf("Expected the key and 2 previous keys, got %v, %v, %v", key, previous, err)
+	}
+
+	// the environment is used without the files
+	t.Setenv(EncryptionKeyEnv, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	t.Setenv(PreviousEncryptionKeysEnv, "")
+	if key, previous, err := LoadEncryptionKeys("", ""); err != nil || key == nil || len(previous) != 0 {
+		t.Errorf("Expected the key of the environment, got %v, %v, %v", key, previous, err)
+	}
+	t.Setenv(PreviousEncryptionKeysEnv, "not base64!")
+	if _, _, err := LoadEncryptionKeys("", ""); err != errKeyFormat {
+		t.Errorf("Expected %v, got %v", errKeyFormat, err)
+	}
+
+	t.Setenv(EncryptionKeyEnv, "")
+	if key, _, err := LoadEncryptionKeys("", ""); err != nil || key != nil {
+		t.Errorf("Expected no key, got %v, %v", key, err)
+	}
+}
+
+func TestEncryptedSnapshot(t *testing.T) {
+	oldKey, newKey := testKey(t), testKey(t)
+	entries := []SnapshotEntry{
+		{Key: "secret", Value: "plain text value", Expire: 1700000000},
+		{Key: "list", Value: []string{"plain text value"}},
+		{Key: "hash", Value: map[string]string{"field": "plain text value"}},
+	}
+
+	var buf bytes.Buffer
+	writer, err := NewSnapshotWriter(&buf, time.Now(), true, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		writer.Write(entry)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if bytes.Contains(data, []byte("plain text")) || bytes.Contains(data, []byte("secret")) {
+		t.Error("Expected the snapshot to be encrypted")
+	}
+
+	// the snapshot written with the rotated key is still readable
+	reader, err := NewSnapshotReader(bytes.NewReader(data), newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reader.Compressed() || !reader.Encrypted() {
+		t.Error("Expected compressed and encrypted snapshot")
+	}
+	var read []SnapshotEntry
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			break
+		}
+		read = append(read, entry)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("Expected %v, got %v", entries, read)
+	}
+
+	for _, keys := range [][]*EncryptionKey{nil, {newKey}} {
+		reader, _ := NewSnapshotReader(bytes.NewReader(data), keys...)
+		if _, err := reader.Next(); err != errUnknownKey {
+			t.Errorf("Expected %v, got %v", errUnknownKey, err)
+		}
+	}
+}
+
+func TestEncryptedAppendOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.aof")
+	oldKey, newKey := testKey(t), testKey(t)
+
+	open := func(options ...Option) (*DataStore, error) {
+		options = append(options, WithWorkers(NoWorkers), WithAppendOnly(path, FsyncAlways))
+		return NewWithOptions(options...)
+	}
+
+	dataStore, err := open(WithCompression(), WithEncryption(oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataStore.Set("rewritten", "plain text value", NoExpiration)
+	dataStore.HSet("hash", "field", "plain text value")
+	if err := dataStore.RewriteAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	dataStore.LPush("appended", "plain text value")
+	dataStore.Close()
+
+	content, _ := os.ReadFile(path)
+	if bytes.Contains(content, []byte("plain text")) {
+		t.Error("Expected the append-only file to be encrypted")
+	}
+
+	// the key is rotated, the records of the old key are replayed
+	// and the rewrite encrypts all of them with the new one
+	dataStore, err = open(WithEncryption(newKey, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := dataStore.Size(); size != 3 {
+		t.Errorf("Expected 3 replayed items, got %d", size)
+	}
+	if err := dataStore.RewriteAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	dataStore.Close()
+
+	if _, err := open(WithEncryption(oldKey)); err != errUnknownKey {
+		t.Fatalf("Expected %v, got %v", errUnknownKey, err)
+	}
+
+	dataStore, err = open(WithEncryption(newKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataStore.Close()
+
+	if list, _ := dataStore.List("appended"); !reflect.DeepEqual(list, []string{"plain text value"}) {
+		t.Errorf("Expected replayed list, got %v", list)
+	}
+	if hash, _ := dataStore.Hash("hash"); hash["field"] != "plain text value" {
+		t.Errorf("Expected replayed hash, got %v", hash)
+	}
+}
+
+func TestEncryptedAppendOnlyTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.aof")
+	key := testKey(t)
+
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithAppendOnly(path, FsyncAlways), WithEncryption(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataStore.Set("a", "1", NoExpiration)
+	dataStore.Set("b", "2", NoExpiration)
+	dataStore.Close()
+
+	// the crash in the middle of the frame
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-5)
+
+	dataStore, err = NewWithOptions(WithWorkers(NoWorkers), WithAppendOnly(path, FsyncAlways), WithEncryption(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataStore.Close()
+
+	if _, err := dataStore.Get("a"); err != nil {
+		t.Errorf("Expected the complete frame to be replayed, got %v", err)
+	}
+	if _, err := dataStore.Get("b"); err != ErrNoItem {
+		t.Errorf("Expected %v, got %v", ErrNoItem, err)
+	}
+
+	// the tampered frame isn't replayed
+	dataStore.Close()
+	content, _ := os.ReadFile(path)
+	content[len(content)-3]++
+	os.WriteFile(path, content, 0644)
+	if _, err := NewWithOptions(WithWorkers(NoWorkers), WithAppendOnly(path, FsyncAlways), WithEncryption(key)); err != errDecryption {
+		t.Errorf("Expected %v, got %v", errDecryption, err)
+	}
+}
@@ -0,0 +1,306 @@
+package inmemory
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// PolicyFactory creates a new instance of the eviction policy for the data store.
+type PolicyFactory func() EvictionPolicy
+
+var (
+	// eviction policies table
+	policies = map[string]PolicyFactory{
+		"allkeys-lru":  newAllKeysLRU,
+		"volatile-lru": newVolatileLRU,
+		// approximated LRU allows the read commands to run in parallel
+		"allkeys-approx-lru":  newAllKeysApproxLRU,
+		"volatile-approx-lru": newVolatileApproxLRU,
+		"allkeys-lfu":         newAllKeysLFU,
+		"volatile-lfu":        newVolatileLFU,
+		"allkeys-tinylfu":     newTinyLFU,
+		"volatile-ttl":        newVolatileTTL,
+		"allkeys-random":      newAllKeysRandom,
+		"volatile-random":     newVolatileRandom,
+		"noeviction":          newNoEviction,
+	}
+
+	// default eviction policy for the data store
+	defaultPolicy = "allkeys-lru"
+	// number of keys inspected by the sampling policies to pick one victim
+	evictionSamples = 5
+
+	errNoSuchPolicy = errors.New("no such eviction policy")
+)
+
+// EvictionPolicy decides which keys are removed from the data store when
+// it's exceeding the memory limit.
+// Every shard of the data store has its own policy and notifies it about
+// every change of its keys, so the policy can keep its own bookkeeping.
+// The methods are called with the shard locked for writing, except Access
+// of ConcurrentPolicy, see below.
+type EvictionPolicy interface {
+	// Add is called when the new item is stored by the key.
+	Add(key string, item *Item)
+	// Access is called when the item is read or modified.
+	Access(key string, item *Item)
+	// Remove is called when the item is removed from the data store.
+	Remove(key string, item *Item)
+	// Evict returns up to n keys to remove from the data store.
+	// values are all the items currently kept in the data store.
+	// Empty result means there is nothing the policy is allowed to evict.
+	Evict(values map[string]*Item, n int) []string
+}
+
+// ConcurrentPolicy is implemented by the eviction policies which track
+// the accesses with atomic operations. If ConcurrentAccess reports true,
+// Access is called concurrently with the shard locked only for reading,
+// so the read commands don't block each other. The other methods are still
+// called with the shard locked for writing.
+type ConcurrentPolicy interface {
+	EvictionPolicy
+	// ConcurrentAccess reports whether Access is safe for the concurrent use.
+	ConcurrentAccess() bool
+}
+
+// OrderedPolicy is implemented by the eviction policies keeping the keys
+// in the order of their usage. The order is saved in the snapshots, so the
+// restored keys are evicted in the same order.
+type OrderedPolicy interface {
+	EvictionPolicy
+	// Order returns the keys from the least to the most recently used one.
+	// It's called with the shard locked for reading, so it has to be safe
+	// for the concurrent Access calls, if the policy is concurrent.
+	Order() []string
+}
+
+// RegisterEvictionPolicy adds the policy to the list of policies
+// available by name for the new data stores.
+func RegisterEvictionPolicy(name string, factory PolicyFactory) {
+	policies[name] = factory
+}
+
+// lru keeps the keys ordered by the recency of usage.
+// The most recently used key is at the front of the list.
+type lru struct {
+	cache *list.List
+}
+
+func newLRU() lru {
+	return lru{cache: list.New()}
+}
+
+func (p lru) Add(key string, item *Item) {
+	item.el = p.cache.PushFront(key)
+}
+
+func (p lru) Access(key string, item *Item) {
+	if item.el != nil {
+		p.cache.MoveToFront(item.el)
+	}
+}
+
+func (p lru) Remove(key string, item *Item) {
+	if item.el != nil {
+		p.cache.Remove(item.el)
+		// break the link from the Item for element in the cache for safe removal
+		item.el = nil
+	}
+}
+
+// Order returns the keys from the least to the most recently used one.
+func (p lru) Order() []string {
+	keys := make([]string, 0, p.cache.Len())
+	for el := p.cache.Back(); el != nil; el = el.Prev() {
+		keys = append(keys, el.Value.(string))
+	}
+	return keys
+}
+
+// allKeysLRU evicts the least recently used keys.
+type allKeysLRU struct {
+	lru
+}
+
+func newAllKeysLRU() EvictionPolicy {
+	return allKeysLRU{newLRU()}
+}
+
+func (p allKeysLRU) Evict(values map[string]*Item, n int) []string {
+	var keys []string
+	for el := p.cache.Back(); el != nil && len(keys) < n; el = el.Prev() {
+		keys = append(keys, el.Value.(string))
+	}
+	return keys
+}
+
+// volatileLRU evicts the least recently used keys among the keys with ttl.
+type volatileLRU struct {
+	lru
+}
+
+func newVolatileLRU() EvictionPolicy {
+	return volatileLRU{newLRU()}
+}
+
+func (p volatileLRU) Evict(values map[string]*Item, n int) []string {
+	var keys []string
+	for el := p.cache.Back(); el != nil && len(keys) < n; el = el.Prev() {
+		key := el.Value.(string)
+		if item, ok := values[key]; ok && item.expire != 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// approxLRU evicts the keys with the oldest access time among the sampled keys.
+// Unlike the exact LRU, it doesn't reorder the list on every access, but
+// only stores the access time of the item atomically.
+type approxLRU struct {
+	sampler
+}
+
+func newAllKeysApproxLRU() EvictionPolicy {
+	return approxLRU{sampler{less: accessedBefore}}
+}
+
+func newVolatileApproxLRU() EvictionPolicy {
+	return approxLRU{sampler{less: accessedBefore, filter: volatile}}
+}
+
+func (p approxLRU) Add(key string, item *Item) {
+	atomic.StoreInt64(&item.access, time.Now().UnixNano())
+}
+
+func (p approxLRU) Access(key string, item *Item) {
+	atomic.StoreInt64(&item.access, time.Now().UnixNano())
+}
+
+// ConcurrentAccess reports that Access can be called under the shared lock.
+func (p approxLRU) ConcurrentAccess() bool {
+	return true
+}
+
+func accessedBefore(a, b *Item) bool {
+	return atomic.LoadInt64(&a.access) < atomic.LoadInt64(&b.access)
+}
+
+// sampler picks victims by looking at several random keys and choosing
+// the worst of them, just like the approximated algorithms do.
+// less reports whether item a should be evicted before item b.
+// filter skips the items that can't be evicted.
+type sampler struct {
+	less   func(a, b *Item) bool
+	filter func(item *Item) bool
+}
+
+func (s sampler) Add(key string, item *Item)    {}
+func (s sampler) Access(key string, item *Item) {}
+func (s sampler) Remove(key string, item *Item) {}
+
+func (s sampler) Evict(values map[string]*Item, n int) []string {
+	var keys []string
+	picked := make(map[string]struct{})
+
+	for len(keys) < n {
+		var (
+			victim     string
+			victimItem *Item
+			sampled    int
+		)
+
+		// map iteration order is random, so first keys are the sample
+		for key, item := range values {
+			if _, ok := picked[key]; ok {
+				continue
+			}
+			if s.filter != nil && !s.filter(item) {
+				continue
+			}
+			if victimItem == nil || s.less(item, victimItem) {
+				victim, victimItem = key, item
+			}
+			sampled++
+			if sampled == evictionSamples {
+				break
+			}
+		}
+
+		if victimItem == nil {
+			break
+		}
+		picked[victim] = struct{}{}
+		keys = append(keys, victim)
+	}
+
+	return keys
+}
+
+func volatile(item *Item) bool {
+	return item.expire != 0
+}
+
+// newVolatileTTL creates policy evicting the keys with the nearest expiration.
+func newVolatileTTL() EvictionPolicy {
+	return sampler{
+		less:   func(a, b *Item) bool { return a.expire < b.expire },
+		filter: volatile,
+	}
+}
+
+// random evicts the keys in random order.
+type random struct {
+	filter func(item *Item) bool
+}
+
+func newAllKeysRandom() EvictionPolicy {
+	return random{}
+}
+
+func newVolatileRandom() EvictionPolicy {
+	return random{filter: volatile}
+}
+
+func (p random) Add(key string, item *Item)    {}
+func (p random) Access(key string, item *Item) {}
+func (p random) Remove(key string, item *Item) {}
+
+func (p random) Evict(values map[string]*Item, n int) []string {
+	var keys []string
+	for key, item := range values {
+		if len(keys) == n {
+			break
+		}
+		if p.filter == nil || p.filter(item) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// noEviction never evicts keys, so the write commands fail
+// once the memory limit is reached.
+type noEviction struct{}
+
+func newNoEviction() EvictionPolicy {
+	return noEviction{}
+}
+
+func (p noEviction) Add(key string, item *Item)                    {}
+func (p noEviction) Access(key string, item *Item)                 {}
+func (p noEviction) Remove(key string, item *Item)                 {}
+func (p noEviction) Evict(values map[string]*Item, n int) []string { return nil }
+
+// EvictionPolicies returns sorted names of all available eviction policies.
+func EvictionPolicies() []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
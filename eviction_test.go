@@ -0,0 +1,235 @@
+package inmemory
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// setup new data store with given eviction policy and create client object for it
+// The data store has one shard, so the policy sees all the keys.
+// Workers are not started, the tests run the eviction themselves.
+func setupPolicyClient(t *testing.T, policy string) *Client {
+	dataStore, err := NewWithOptions(WithEvictionPolicy(policy), WithShards(1), WithWorkers(NoWorkers))
+	if err != nil {
+		t.Fatalf("Couldn't create data store with policy %s: %v", policy, err)
+	}
+	return NewClient(dataStore)
+}
+
+// evict asks the policy of the client's data store first shard for n victims
+func evict(client *Client, n int) []string {
+	shard := client.ds.shards[0]
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.policy.Evict(shard.values, n)
+}
+
+func TestNewWithPolicy(t *testing.T) {
+	for _, name := range EvictionPolicies() {
+		dataStore, err := NewWithPolicy(name)
+		if err != nil {
+			t.Errorf("Couldn't create data store with policy %s: %v", name, err)
+			continue
+		}
+		dataStore.Close()
+	}
+
+	if _, err := NewWithPolicy("wrong-policy"); err != errNoSuchPolicy {
+		t.Errorf("Expected error: %#v, got: %#v", errNoSuchPolicy, err)
+	}
+}
+
+func TestAllKeysLRU(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-lru")
+
+	testData(client, 4)
+	client.Exec("get", []string{"key0"})
+	client.Exec("set", []string{"key1", "1"})
+
+	expected := []string{"key2", "key3", "key0"}
+	keys := evict(client, 3)
+
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected victims: %v, got: %v", expected, keys)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Errorf("Expected victims: %v, got: %v", expected, keys)
+		}
+	}
+}
+
+func TestVolatileLRU(t *testing.T) {
+	client := setupPolicyClient(t, "volatile-lru")
+
+	client.Exec("lpush", []string{"list", "value"})
+	client.Exec("set", []string{"persistent", "value", "0"})
+	client.Exec("set", []string{"volatile", "value", "30"})
+
+	keys := evict(client, 3)
+	if len(keys) != 1 || keys[0] != "volatile" {
+		t.Errorf("Expected victims: [volatile], got: %v", keys)
+	}
+}
+
+func TestApproxLRU(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-approx-lru")
+
+	testData(client, evictionSamples)
+
+	// make key2 the least recently accessed
+	client.ds.shards[0].values["key2"].access = 0
+
+	keys := evict(client, 1)
+	if len(keys) != 1 || keys[0] != "key2" {
+		t.Errorf("Expected victims: [key2], got: %v", keys)
+	}
+
+	client = setupPolicyClient(t, "volatile-approx-lru")
+
+	client.Exec("set", []string{"persistent", "value", "0"})
+	client.Exec("set", []string{"volatile", "value", "30"})
+
+	keys = evict(client, 2)
+	if len(keys) != 1 || keys[0] != "volatile" {
+		t.Errorf("Expected victims: [volatile], got: %v", keys)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	concurrent := map[string]bool{
+		"allkeys-lru":        false,
+		"allkeys-tinylfu":    false,
+		"allkeys-lfu":        true,
+		"allkeys-approx-lru": true,
+	}
+
+	for policy, expected := range concurrent {
+		client := setupPolicyClient(t, policy)
+		if client.ds.shards[0].concurrent != expected {
+			t.Errorf("%s: expected concurrent access: %v", policy, expected)
+		}
+
+		testData(client, 10)
+		client.Exec("lpush", []string{"list", "value"})
+		client.Exec("hset", []string{"hash", "key", "value"})
+
+		// concurrent reads have to be safe under the race detector
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				reader := NewClient(client.ds)
+				for j := 0; j < 100; j++ {
+					reader.Exec("get", []string{"key" + strconv.Itoa(j%10)})
+					reader.Exec("lget", []string{"list", "0"})
+					reader.Exec("hget", []string{"hash", "key"})
+				}
+			}()
+		}
+		wg.Wait()
+
+		if stats := client.ds.Stats(); stats.Hits != 2400 {
+			t.Errorf("%s: expected 2400 hits, got: %d", policy, stats.Hits)
+		}
+	}
+}
+
+func TestAllKeysLFU(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-lfu")
+
+	testData(client, evictionSamples)
+	for i := 0; i < evictionSamples; i++ {
+		if i == 3 {
+			continue
+		}
+		client.Exec("get", []string{"key" + strconv.Itoa(i)})
+	}
+
+	keys := evict(client, 1)
+	if len(keys) != 1 || keys[0] != "key3" {
+		t.Errorf("Expected victims: [key3], got: %v", keys)
+	}
+}
+
+func TestVolatileTTL(t *testing.T) {
+	client := setupPolicyClient(t, "volatile-ttl")
+
+	client.Exec("set", []string{"late", "value", "300"})
+	client.Exec("set", []string{"soon", "value", "30"})
+	client.Exec("set", []string{"persistent", "value", "0"})
+
+	keys := evict(client, 3)
+	if len(keys) != 2 || keys[0] != "soon" || keys[1] != "late" {
+		t.Errorf("Expected victims: [soon late], got: %v", keys)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-random")
+
+	testData(client, 10)
+
+	keys := evict(client, 4)
+	if len(keys) != 4 {
+		t.Errorf("Expected 4 victims, got: %v", keys)
+	}
+
+	client = setupPolicyClient(t, "volatile-random")
+
+	client.Exec("set", []string{"persistent", "value", "0"})
+	client.Exec("set", []string{"volatile", "value", "30"})
+
+	keys = evict(client, 4)
+	if len(keys) != 1 || keys[0] != "volatile" {
+		t.Errorf("Expected victims: [volatile], got: %v", keys)
+	}
+}
+
+func TestNoEviction(t *testing.T) {
+	client := setupPolicyClient(t, "noeviction")
+
+	testData(client, 10)
+
+	if keys := evict(client, 10); len(keys) != 0 {
+		t.Errorf("Expected no victims, got: %v", keys)
+	}
+
+	// emulate memoryd detecting the exceeded memory limit
+	atomic.StoreInt32(&client.ds.oom, 1)
+
+	writes := map[string][]string{
+		"SET":   {"key", "value"},
+		"LPUSH": {"list", "value"},
+		"LSET":  {"list", "0", "value"},
+		"HSET":  {"hash", "key", "value"},
+	}
+	for command, args := range writes {
+		if _, err := client.Exec(command, args); err != ErrOOM {
+			t.Errorf("%s: expected error: %#v, got: %#v", command, ErrOOM, err)
+		}
+	}
+
+	// reads and removals are still allowed
+	if reply, err := client.Exec("get", []string{"key1"}); reply != "1" || err != nil {
+		t.Errorf("Expected reply: \"1\", got: \"%s\", %#v", reply, err)
+	}
+	if _, err := client.Exec("remove", []string{"key1"}); err != nil {
+		t.Errorf("Expected error: <nil>, got: %#v", err)
+	}
+}
+
+func TestSetReplacesCacheEntry(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-lru")
+
+	client.Exec("set", []string{"x", "1"})
+	client.Exec("set", []string{"x", "2"})
+
+	keys := evict(client, 10)
+	if len(keys) != 1 {
+		t.Errorf("Expected one cache entry for the key, got: %v", keys)
+	}
+}
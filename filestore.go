@@ -0,0 +1,101 @@
+package inmemory
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// FileStore is the backing store keeping every key in its own gob file
+// in the local directory. It's meant for the tests and the development.
+// FileStore can be used as the Loader of the string values as well.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates the file store in the given directory.
+// The directory is created if it doesn't exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of the key. The key is hex encoded to be
+// the valid file name.
+func (store *FileStore) path(key string) string {
+	return filepath.Join(store.dir, hex.EncodeToString([]byte(key))+".gob")
+}
+
+// Store saves the values of the writes to the files, the files of the
+// removed keys are deleted. Every file is replaced atomically.
+func (store *FileStore) Store(writes []Write) error {
+	for _, write := range writes {
+		path := store.path(write.Key)
+
+		if write.Value == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		if err := store.save(path, write.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save writes the value to the temporary file and renames it to the path.
+func (store *FileStore) save(path string, value interface{}) error {
+	file, err := os.CreateTemp(store.dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := gob.NewEncoder(file).Encode(&value); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Get reads the value of the key, ErrNoItem is returned if there is no such key.
+func (store *FileStore) Get(key string) (interface{}, error) {
+	file, err := os.Open(store.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNoItem
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var value interface{}
+	if err := gob.NewDecoder(file).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Load reads the string value of the key.
+func (store *FileStore) Load(ctx context.Context, key string) (string, error) {
+	value, err := store.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	result, ok := value.(string)
+	if !ok {
+		return "", ErrNotString
+	}
+	return result, nil
+}
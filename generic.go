@@ -0,0 +1,239 @@
+package inmemory
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	errCachePersistence = errors.New("cache values can't be persisted")
+	errMaxSize          = errors.New("max size should be > 0")
+)
+
+// Cache is the in-process cache of arbitrary Go values. It uses the same
+// shards, eviction policies and expiration as the DataStore, but the values
+// are stored as they are, without conversion to the strings.
+// The keys are converted to the string keys of the data store: string and
+// integer keys are used as they are, the other keys by their Go syntax
+// representation and pointers by their address.
+type Cache[K comparable, V any] struct {
+	store *DataStore
+	key   func(K) string
+
+	// size returns the size of the value for the bounded cache,
+	// the total size of the values is kept within maxSize
+	size    func(V) int64
+	maxSize int64
+}
+
+// cacheEntry is the value of the data store item holding the cached value
+// with its original key.
+type cacheEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewCache creates new cache configured by the options. Only ttld is started
+// by default, memoryd can be added with WithWorkers. The cached values are
+// not persisted, so PersistenceWorker, WithFinalSnapshot, WithRestore,
+// the backing store and the append-only file are not allowed.
+// The cache has to be closed with Close to stop its workers.
+func NewCache[K comparable, V any](options ...Option) (*Cache[K, V], error) {
+
+	config, err := newConfig(append([]Option{WithWorkers(TTLWorker)}, options...))
+	if err != nil {
+		return nil, err
+	}
+	if config.workers&PersistenceWorker != 0 || config.finalSnapshot || config.backing != nil || config.appendOnly != "" || config.restore {
+		return nil, errCachePersistence
+	}
+
+	store, err := newDataStore(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache[K, V]{
+		store: store,
+		key:   cacheKey[K](),
+	}, nil
+}
+
+// NewBoundedCache creates new cache which keeps the total size of the values
+// within maxSize. The size of every value is given by the size function,
+// e.g. its length in bytes. Without the size function every value has size 1,
+// so maxSize limits the number of values. When the cache is full, the values
+// are evicted according to the eviction policy before the new one is set.
+// The concurrent Sets of the keys of the different shards can exceed maxSize
+// by the sizes of their values, the following Sets evict the excess.
+func NewBoundedCache[K comparable, V any](maxSize int64, size func(V) int64, options ...Option) (*Cache[K, V], error) {
+
+	if maxSize <= 0 {
+		return nil, errMaxSize
+	}
+
+	cache, err := NewCache[K, V](options...)
+	if err != nil {
+		return nil, err
+	}
+	if size == nil {
+		size = func(V) int64 { return 1 }
+	}
+	cache.size = size
+	cache.maxSize = maxSize
+	return cache, nil
+}
+
+// cacheKey returns the function converting the keys of type K to the
+// string keys of the data store.
+func cacheKey[K comparable]() func(K) string {
+
+	// the keys of interface type can hold values of any type,
+	// so they are always converted with their type
+	if reflect.TypeOf((*K)(nil)).Elem().Kind() != reflect.Interface {
+		var zero K
+		switch any(zero).(type) {
+		case string:
+			return func(key K) string { return any(key).(string) }
+		case int:
+			return func(key K) string { return strconv.Itoa(any(key).(int)) }
+		case int64:
+			return func(key K) string { return strconv.FormatInt(any(key).(int64), 10) }
+		case uint64:
+			return func(key K) string { return strconv.FormatUint(any(key).(uint64), 10) }
+		}
+	}
+
+	return func(key K) string {
+		if value := reflect.ValueOf(key); value.Kind() == reflect.Ptr {
+			return fmt.Sprintf("%T %x", key, value.Pointer())
+		}
+		return fmt.Sprintf("%T %#v", key, key)
+	}
+}
+
+// Get retrieves the value by given key.
+// If value was successfully read, it will be updated as the most recently used in cache.
+func (cache *Cache[K, V]) Get(key K) (V, error) {
+
+	k := cache.key(key)
+
+	shard := cache.store.shard(k)
+	shard.lockAccess()
+	defer shard.unlockAccess()
+
+	item, ok := shard.lookup(k)
+	if !ok {
+		var zero V
+		return zero, ErrNoItem
+	}
+
+	shard.touch(k, item)
+	return item.Value.(cacheEntry[K, V]).value, nil
+}
+
+// Set stores the value by given key, replacing the previous one.
+// The value expires after ttl, NoExpiration and DefaultExpiration are accepted.
+// ErrOOM is returned if the value doesn't fit in the bounded cache.
+func (cache *Cache[K, V]) Set(key K, value V, ttl time.Duration) error {
+
+	expire, err := expireAt(ttl)
+	if err != nil {
+		return err
+	}
+
+	if cache.store.outOfMemory() {
+		return ErrOOM
+	}
+
+	item := &Item{
+		Value:  cacheEntry[K, V]{key: key, value: value},
+		expire: expire,
+	}
+	if cache.size != nil {
+		item.size = cache.size(value)
+		if item.size > cache.maxSize {
+			return ErrOOM
+		}
+	}
+
+	k := cache.key(key)
+
+	shard := cache.store.shard(k)
+	shard.Lock()
+	defer shard.Unlock()
+
+	// make room for the value in the bounded cache, the replaced value
+	// frees its size
+	if cache.size != nil {
+		grows := func() int64 {
+			if old, ok := shard.values[k]; ok {
+				return item.size - old.size
+			}
+			return item.size
+		}
+		if !cache.store.reserve(grows, cache.maxSize, shard) {
+			return ErrOOM
+		}
+	}
+
+	shard.set(k, item)
+	return nil
+}
+
+// Remove deletes the value by given key.
+func (cache *Cache[K, V]) Remove(key K) error {
+	return cache.store.Remove(cache.key(key))
+}
+
+// Expire updates ttl of the value by given key.
+// NoExpiration and DefaultExpiration are accepted.
+func (cache *Cache[K, V]) Expire(key K, ttl time.Duration) error {
+	return cache.store.Expire(cache.key(key), ttl)
+}
+
+// Keys returns all keys which are currently in the cache.
+func (cache *Cache[K, V]) Keys() []K {
+
+	cache.store.rlockAll()
+	defer cache.store.runlockAll()
+
+	var keys []K
+	for _, shard := range cache.store.shards {
+		for _, item := range shard.values {
+			keys = append(keys, item.Value.(cacheEntry[K, V]).key)
+		}
+	}
+	return keys
+}
+
+// OnRemoval registers the listener called for every value removed from the
+// cache. The listeners are called the same way as for the DataStore.
+func (cache *Cache[K, V]) OnRemoval(listener func(key K, value V, reason RemovalReason)) {
+	cache.store.OnRemoval(func(_ string, value interface{}, reason RemovalReason) {
+		entry := value.(cacheEntry[K, V])
+		listener(entry.key, entry.value, reason)
+	})
+}
+
+// Size returns number of all values in the cache.
+func (cache *Cache[K, V]) Size() int {
+	return cache.store.Size()
+}
+
+// Used returns the total size of the values in the bounded cache.
+func (cache *Cache[K, V]) Used() int64 {
+	return cache.store.used()
+}
+
+// Stats returns the current statistics of the cache.
+func (cache *Cache[K, V]) Stats() Stats {
+	return cache.store.Stats()
+}
+
+// Close stops the workers of the cache and waits for them to finish.
+func (cache *Cache[K, V]) Close() error {
+	return cache.store.Close()
+}
@@ -0,0 +1,226 @@
+package inmemory
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// level is the named key type, not converted like int
+type level int
+
+type user struct {
+	Name string
+	Tags []string
+}
+
+func TestCache(t *testing.T) {
+	cache, err := NewCache[string, *user]()
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+	defer cache.Close()
+
+	john := &user{Name: "john", Tags: []string{"admin"}}
+	if err := cache.Set("john", john, NoExpiration); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	value, err := cache.Get("john")
+	if err != nil || value != john {
+		t.Errorf("Expected the same value, got: %v, %v", value, err)
+	}
+	if _, err := cache.Get("jane"); err != ErrNoItem {
+		t.Errorf("Expected %v, got: %v", ErrNoItem, err)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got: %+v", stats)
+	}
+
+	if err := cache.Expire("john", time.Minute); err != nil {
+		t.Errorf("Expire failed: %v", err)
+	}
+	if err := cache.Remove("john"); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+	if size := cache.Size(); size != 0 {
+		t.Errorf("Expected empty cache, got size: %d", size)
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	type point struct{ X, Y int }
+
+	points, err := NewCache[point, string]()
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+	defer points.Close()
+
+	points.Set(point{1, 2}, "a", NoExpiration)
+	points.Set(point{2, 1}, "b", NoExpiration)
+	if value, _ := points.Get(point{1, 2}); value != "a" {
+		t.Errorf("Expected a, got: %q", value)
+	}
+	keys := points.Keys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].X < keys[j].X })
+	if !reflect.DeepEqual(keys, []point{{1, 2}, {2, 1}}) {
+		t.Errorf("Expected original keys, got: %v", keys)
+	}
+
+	// the keys of the named types are converted by their representation
+	levels, err := NewCache[level, int]()
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+	defer levels.Close()
+
+	levels.Set(level(1), 1, NoExpiration)
+	levels.Set(level(2), 2, NoExpiration)
+	if value, _ := levels.Get(level(2)); value != 2 {
+		t.Errorf("Expected 2, got: %d", value)
+	}
+	if key := levels.key(level(1)); key != "inmemory.level 1" {
+		t.Errorf("Expected key with the type, got: %q", key)
+	}
+
+	// pointers are compared by the address
+	first, second := &user{Name: "john"}, &user{Name: "john"}
+	pointers, err := NewCache[*user, int]()
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+	defer pointers.Close()
+
+	pointers.Set(first, 1, NoExpiration)
+	pointers.Set(second, 2, NoExpiration)
+	if value, _ := pointers.Get(first); value != 1 {
+		t.Errorf("Expected 1, got: %d", value)
+	}
+}
+
+func TestBoundedCache(t *testing.T) {
+	cache, err := NewBoundedCache[int, []byte](10, func(value []byte) int64 {
+		return int64(len(value))
+	}, WithShards(4))
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+	defer cache.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := cache.Set(i, make([]byte, 3), NoExpiration); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if used := cache.Used(); used > 10 {
+			t.Fatalf("Expected used size <= 10, got: %d", used)
+		}
+	}
+	if used, size := cache.Used(), cache.Size(); used != 9 || size != 3 {
+		t.Errorf("Expected 3 values of total size 9, got: %d values of size %d", size, used)
+	}
+
+	// replacing the value updates the used size
+	keys := cache.Keys()
+	cache.Set(keys[0], make([]byte, 1), NoExpiration)
+	if used := cache.Used(); used != 7 {
+		t.Errorf("Expected used size 7, got: %d", used)
+	}
+	cache.Remove(keys[0])
+	if used := cache.Used(); used != 6 {
+		t.Errorf("Expected used size 6, got: %d", used)
+	}
+
+	if err := cache.Set(10, make([]byte, 11), NoExpiration); err != ErrOOM {
+		t.Errorf("Expected %v for too large value, got: %v", ErrOOM, err)
+	}
+}
+
+func TestBoundedCacheReplace(t *testing.T) {
+	cache, err := NewBoundedCache[string, string](3, nil, WithShards(2))
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+	defer cache.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, "value", NoExpiration)
+	}
+
+	// the full cache doesn't evict the others to replace the value
+	for i := 0; i < 10; i++ {
+		if err := cache.Set("a", strconv.Itoa(i), NoExpiration); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if used, size := cache.Used(), cache.Size(); used != 3 || size != 3 {
+			t.Fatalf("Expected 3 values of total size 3, got: %d values of size %d", size, used)
+		}
+	}
+	if value, _ := cache.Get("a"); value != "9" {
+		t.Errorf("Expected the replaced value 9, got: %q", value)
+	}
+}
+
+func TestBoundedCacheBusyShard(t *testing.T) {
+	cache, err := NewBoundedCache[string, string](2, nil, WithShards(2))
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+	defer cache.Close()
+
+	// the keys of both shards
+	keys := map[*shard][]string{}
+	for i := 0; len(keys) < 2 || len(keys[cache.store.shards[0]]) < 2; i++ {
+		key := strconv.Itoa(i)
+		shard := cache.store.shard(key)
+		keys[shard] = append(keys[shard], key)
+	}
+	full, empty := cache.store.shards[0], cache.store.shards[1]
+	for _, key := range keys[full][:2] {
+		cache.Set(key, "value", NoExpiration)
+	}
+
+	// the value is evicted from the shard locked meanwhile
+	full.Lock()
+	time.AfterFunc(10*time.Millisecond, full.Unlock)
+	if err := cache.Set(keys[empty][0], "value", NoExpiration); err != nil {
+		t.Fatalf("Expected the value to be set, got: %v", err)
+	}
+	if used := cache.Used(); used != 2 {
+		t.Errorf("Expected used size 2, got: %d", used)
+	}
+}
+
+func TestBoundedCacheNoEviction(t *testing.T) {
+	cache, err := NewBoundedCache[string, string](2, nil, WithEvictionPolicy("noeviction"))
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+	defer cache.Close()
+
+	cache.Set("a", "a", NoExpiration)
+	cache.Set("b", "b", NoExpiration)
+	if err := cache.Set("c", "c", NoExpiration); err != ErrOOM {
+		t.Errorf("Expected %v, got: %v", ErrOOM, err)
+	}
+}
+
+func TestNewCacheErrors(t *testing.T) {
+	if _, err := NewCache[string, int](WithWorkers(AllWorkers)); err != errCachePersistence {
+		t.Errorf("Expected %v, got: %v", errCachePersistence, err)
+	}
+	if _, err := NewCache[string, int](WithFinalSnapshot()); err != errCachePersistence {
+		t.Errorf("Expected %v, got: %v", errCachePersistence, err)
+	}
+	if _, err := NewCache[string, int](WithWriteThrough(&recordingStore{})); err != errCachePersistence {
+		t.Errorf("Expected %v, got: %v", errCachePersistence, err)
+	}
+	if _, err := NewBoundedCache[string, int](0, nil); err != errMaxSize {
+		t.Errorf("Expected %v, got: %v", errMaxSize, err)
+	}
+	if _, err := NewCache[string, int](WithShards(0)); err != errShardsNumber {
+		t.Errorf("Expected %v, got: %v", errShardsNumber, err)
+	}
+}
@@ -2,6 +2,7 @@ package inmemory
 
 import (
 	"hash/crc32"
+	"io"
 	"sort"
 	"sync"
 )
@@ -147,3 +148,29 @@ func (c *Circle) search(key string) int {
 
 	return sort.Search(len(c.nodes), searchfn)
 }
+
+// WriteMetrics writes the servers of the circle and the number of their
+// virtual nodes in the Prometheus text format.
+func (c *Circle) WriteMetrics(w io.Writer) error {
+	c.RLock()
+	vnodes := make(map[string]int)
+	for _, server := range c.node2server {
+		vnodes[server.Addr]++
+	}
+	servers := len(c.servers)
+	c.RUnlock()
+
+	addrs := make([]string, 0, len(vnodes))
+	for addr := range vnodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	m := &metrics{w: w}
+	m.single("inmemory_ring_servers", "gauge", "Number of the servers in the ring.", float64(servers))
+	m.family("inmemory_ring_vnodes", "gauge", "Number of the virtual nodes of the server in the ring.")
+	for _, addr := range addrs {
+		m.sample("inmemory_ring_vnodes", float64(vnodes[addr]), "server", addr)
+	}
+	return m.err
+}
@@ -0,0 +1,199 @@
+package inmemory
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errNoSection = errors.New("no such info section")
+
+// counters hold the statistics of the data store which aren't tracked
+// by the shards. The numbers are changed atomically, the backup status
+// is protected by the mutex.
+type counters struct {
+	commands uint64
+	// changes is the number of the writes since the last backup
+	changes int64
+
+	started time.Time
+
+	sync.Mutex
+	saving bool
+	// lastBackup is the time the last backup finished, successfully
+	// or not, lastSave is the time of the last successful one
+	lastBackup         time.Time
+	lastBackupErr      error
+	lastBackupDuration time.Duration
+	lastSave           time.Time
+	// statsBase are the totals at the last ResetStats, the counters
+	// themselves are never reset, as they are exported as metrics
+	statsBase Stats
+}
+
+// infoSection is the named group of the fields reported by INFO.
+type infoSection struct {
+	name   string
+	fields func(dataStore *DataStore) [][2]string
+}
+
+// infoSections in the order they are reported
+var infoSections = []infoSection{
+	{"Server", serverInfo},
+	{"Memory", memoryInfo},
+	{"Keyspace", keyspaceInfo},
+	{"Stats", statsInfo},
+	{"Persistence", persistenceInfo},
+}
+
+// Info returns the report about the data store in the format of Redis INFO:
+// the sections start with "# Name" line and the fields are "name:value" lines.
+// Only the given section is reported, e.g. "memory", all of them if it's
+// empty or "all".
+func (dataStore *DataStore) Info(section string) (string, error) {
+
+	var lines []string
+	for _, s := range infoSections {
+		if section != "" && !strings.EqualFold(section, "all") && !strings.EqualFold(section, s.name) {
+			continue
+		}
+
+		lines = append(lines, "# "+s.name)
+		for _, field := range s.fields(dataStore) {
+			lines = append(lines, field[0]+":"+field[1])
+		}
+	}
+
+	if len(lines) == 0 {
+		return "", errNoSection
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func serverInfo(dataStore *DataStore) [][2]string {
+	uptime := time.Since(dataStore.counters.started) / time.Second
+
+	return [][2]string{
+		{"go_version", runtime.Version()},
+		{"uptime_in_seconds", fmt.Sprint(int64(uptime))},
+		{"shards", fmt.Sprint(len(dataStore.shards))},
+		{"connected_clients", fmt.Sprint(dataStore.clients.len())},
+	}
+}
+
+func memoryInfo(dataStore *DataStore) [][2]string {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	oom := 0
+	if dataStore.outOfMemory() {
+		oom = 1
+	}
+
+	return [][2]string{
+		{"used_memory", fmt.Sprint(memStats.Alloc)},
+		{"maxmemory", fmt.Sprint(dataStore.config.maxMemory)},
+		{"maxmemory_policy", dataStore.config.policy},
+		{"out_of_memory", fmt.Sprint(oom)},
+	}
+}
+
+func keyspaceInfo(dataStore *DataStore) [][2]string {
+	dataStore.rlockAll()
+	defer dataStore.runlockAll()
+
+	keys, expires := 0, 0
+	for _, shard := range dataStore.shards {
+		keys += len(shard.values)
+		expires += len(shard.expires)
+	}
+
+	return [][2]string{
+		{"keys", fmt.Sprint(keys)},
+		{"expires", fmt.Sprint(expires)},
+	}
+}
+
+func statsInfo(dataStore *DataStore) [][2]string {
+	stats := dataStore.Stats()
+
+	return [][2]string{
+		{"total_commands_processed", fmt.Sprint(stats.Commands)},
+		{"keyspace_hits", fmt.Sprint(stats.Hits)},
+		{"keyspace_misses", fmt.Sprint(stats.Misses)},
+		{"hit_rate", fmt.Sprintf("%.4f", stats.HitRate())},
+		{"expired_keys", fmt.Sprint(stats.Expired)},
+		{"evicted_keys", fmt.Sprint(stats.Evicted)},
+	}
+}
+
+func persistenceInfo(dataStore *DataStore) [][2]string {
+	dataStore.counters.Lock()
+	lastBackup, lastBackupErr := dataStore.counters.lastBackup, dataStore.counters.lastBackupErr
+	saving, duration, lastSave := dataStore.counters.saving, dataStore.counters.lastBackupDuration, dataStore.counters.lastSave
+	dataStore.counters.Unlock()
+
+	// there is no status until the first backup, the error is logged
+	var backupTime int64
+	status := "none"
+	if !lastBackup.IsZero() {
+		backupTime = lastBackup.Unix()
+		status = "ok"
+		if lastBackupErr != nil {
+			status = "err"
+		}
+	}
+
+	inProgress := 0
+	if saving {
+		inProgress = 1
+	}
+	var saveTime int64
+	if !lastSave.IsZero() {
+		saveTime = lastSave.Unix()
+	}
+
+	fields := [][2]string{
+		{"changes_since_last_backup", fmt.Sprint(atomic.LoadInt64(&dataStore.counters.changes))},
+		{"backup_in_progress", fmt.Sprint(inProgress)},
+		{"last_backup_time", fmt.Sprint(backupTime)},
+		{"last_backup_status", status},
+		{"last_backup_duration_sec", fmt.Sprint(int64(duration / time.Second))},
+		{"last_save_time", fmt.Sprint(saveTime)},
+		{"pending_writes", fmt.Sprint(dataStore.writes.size())},
+	}
+	return append(fields, aofInfo(dataStore.aof)...)
+}
+
+func aofInfo(aof *appendOnly) [][2]string {
+	if aof == nil {
+		return [][2]string{{"aof_enabled", "0"}}
+	}
+
+	aof.Lock()
+	defer aof.Unlock()
+
+	rewriting := 0
+	if aof.rewrite != nil {
+		rewriting = 1
+	}
+	status := "none"
+	if aof.rewritten {
+		status = "ok"
+		if aof.lastRewriteErr != nil {
+			status = "err"
+		}
+	}
+
+	return [][2]string{
+		{"aof_enabled", "1"},
+		{"aof_rewrite_in_progress", fmt.Sprint(rewriting)},
+		{"aof_last_rewrite_status", status},
+		{"aof_current_size", fmt.Sprint(aof.size)},
+		{"aof_base_size", fmt.Sprint(aof.baseSize)},
+	}
+}
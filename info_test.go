@@ -0,0 +1,132 @@
+package inmemory
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// infoFields parses the INFO report to the map of the fields.
+func infoFields(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	return fields
+}
+
+func TestInfo(t *testing.T) {
+	dataStore, err := NewWithOptions(WithShards(1), WithWorkers(NoWorkers), WithBackups(t.TempDir(), 1))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	client := NewRemoteClient(dataStore, "127.0.0.1:5000")
+	defer client.Close()
+	client.Exec("SET", []string{"a", "1"})
+	client.Exec("SET", []string{"b", "2", "0"})
+	client.Exec("GET", []string{"a"})
+	client.Exec("GET", []string{"missing"})
+	dataStore.Set("expired", "value", time.Second)
+	dataStore.shards[0].removeExpired(time.Now().Unix() + 10)
+	dataStore.shards[0].evict(1)
+
+	info, err := dataStore.Info("")
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	for _, section := range []string{"# Server", "# Memory", "# Keyspace", "# Stats", "# Persistence"} {
+		if !strings.Contains(info, section) {
+			t.Errorf("Expected section %q in the report: %s", section, info)
+		}
+	}
+
+	expected := map[string]string{
+		"shards":                    "1",
+		"connected_clients":         "1",
+		"maxmemory_policy":          "allkeys-lru",
+		"keys":                      "1",
+		"expires":                   "1",
+		"total_commands_processed":  "4",
+		"keyspace_hits":             "1",
+		"keyspace_misses":           "1",
+		"hit_rate":                  "0.5000",
+		"expired_keys":              "1",
+		"evicted_keys":              "1",
+		"changes_since_last_backup": "3",
+		"last_backup_status":        "none",
+	}
+	fields := infoFields(info)
+	for name, value := range expected {
+		if fields[name] != value {
+			t.Errorf("Expected %s:%s, got: %q", name, value, fields[name])
+		}
+	}
+
+	// the changes are counted since the last backup
+	if err := dataStore.backup(); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	client.Close()
+
+	info, _ = dataStore.Info("persistence")
+	fields = infoFields(info)
+	if fields["changes_since_last_backup"] != "0" || fields["last_backup_status"] != "ok" {
+		t.Errorf("Expected no changes after the backup, got: %s", info)
+	}
+	if strings.Contains(info, "# Server") {
+		t.Errorf("Expected only persistence section, got: %s", info)
+	}
+	if fields["last_backup_time"] == "0" {
+		t.Errorf("Expected last backup time, got: %s", info)
+	}
+
+	info, _ = dataStore.Info("server")
+	if fields := infoFields(info); fields["connected_clients"] != "0" {
+		t.Errorf("Expected no connected clients, got: %s", info)
+	}
+}
+
+func TestInfoCommand(t *testing.T) {
+	client := setupTestClient()
+	defer client.ds.Close()
+
+	client.Exec("GET", []string{"missing"})
+
+	reply, err := client.Exec("INFO", []string{"STATS"})
+	if err != nil {
+		t.Fatalf("INFO failed: %v", err)
+	}
+	if !strings.HasPrefix(reply, "# Stats total_commands_processed:2 keyspace_hits:0 keyspace_misses:1") {
+		t.Errorf("Expected single line stats report, got: %q", reply)
+	}
+
+	if reply, err := client.Exec("CONFIG", []string{"resetstat"}); err != nil || reply != "OK" {
+		t.Errorf("Expected OK, got: %q, %v", reply, err)
+	}
+	if stats := client.ds.Stats(); stats != (Stats{}) {
+		t.Errorf("Expected reset stats, got: %+v", stats)
+	}
+
+	errorCases := []struct {
+		name          string
+		command       string
+		args          []string
+		expectedError error
+	}{
+		{"unknown section", "INFO", []string{"wrong"}, errNoSection},
+		{"too many sections", "INFO", []string{"stats", "memory"}, errArgumentNumber},
+		{"unknown subcommand", "CONFIG", []string{"SET"}, errNoSubcommand},
+		{"no subcommand", "CONFIG", nil, errArgumentNumber},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := client.Exec(tc.command, tc.args); err != tc.expectedError {
+				t.Errorf("Expected %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package inmemory
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// The LFU counter of the item takes 24 bits of Item.lfu:
+//
+//	16 bits      8 bits
+//	+----------+--------+
+//	+ Minutes  | LOG_C  |
+//	+----------+--------+
+//
+// Minutes is the last time the counter was decremented, in minutes modulo 2^16.
+// LOG_C is the logarithmic access counter. It grows slower the higher it is,
+// so 255 is reached only after about a million accesses with the default
+// log factor. The counter is decremented by one for each decay period
+// the item wasn't accessed, so the keys which were hot long ago cool down.
+const (
+	// counter of the new items, so they are not evicted before getting a chance to be used
+	lfuInitValue = 5
+	// max value of the counter
+	lfuMaxValue = 255
+)
+
+var (
+	// the higher the factor, the more accesses are needed to increment the counter
+	lfuLogFactor = 10
+	// number of minutes after which the counter is decremented by one
+	lfuDecayTime = 1
+)
+
+// lfuTime returns current time in minutes, modulo 2^16.
+func lfuTime() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xffff
+}
+
+// lfuElapsed returns number of minutes passed since the given time,
+// taking into account the wrap of the 16 bits clock.
+func lfuElapsed(last uint32) uint32 {
+	now := lfuTime()
+	if now >= last {
+		return now - last
+	}
+	return 0x10000 - last + now
+}
+
+// lfuLogIncr increments the counter logarithmically: the probability of
+// the increment is 1/((counter-init)*factor+1).
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == lfuMaxValue {
+		return counter
+	}
+
+	base := float64(0)
+	if counter > lfuInitValue {
+		base = float64(counter - lfuInitValue)
+	}
+
+	if rand.Float64() < 1.0/(base*float64(lfuLogFactor)+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecr returns the counter of the item, decremented by the number of
+// decay periods elapsed since the last decrement. The item isn't modified.
+func lfuDecr(item *Item) uint32 {
+	return lfuDecay(atomic.LoadUint32(&item.lfu))
+}
+
+// lfuDecay returns the decremented counter from the value of Item.lfu.
+func lfuDecay(value uint32) uint32 {
+	counter := value & 0xff
+
+	if lfuDecayTime == 0 {
+		return counter
+	}
+
+	periods := lfuElapsed(value>>8) / uint32(lfuDecayTime)
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// lfu evicts the keys with the lowest access frequency.
+// The candidates are sampled, as keeping the keys ordered by frequency
+// is too expensive on every access.
+// The counters are updated atomically, so the reads need only shared lock.
+type lfu struct {
+	sampler
+}
+
+func newAllKeysLFU() EvictionPolicy {
+	return lfu{sampler{less: lfuLess}}
+}
+
+func newVolatileLFU() EvictionPolicy {
+	return lfu{sampler{less: lfuLess, filter: volatile}}
+}
+
+func lfuLess(a, b *Item) bool {
+	return lfuDecr(a) < lfuDecr(b)
+}
+
+// Add initializes the counter of the new item.
+func (p lfu) Add(key string, item *Item) {
+	atomic.StoreUint32(&item.lfu, lfuTime()<<8|lfuInitValue)
+}
+
+// Access decays and then increments the counter of the item.
+// If the counter is concurrently updated by another reader, this access
+// is lost, which is fine for the approximate frequency.
+func (p lfu) Access(key string, item *Item) {
+	value := atomic.LoadUint32(&item.lfu)
+	counter := lfuLogIncr(lfuDecay(value))
+	atomic.CompareAndSwapUint32(&item.lfu, value, lfuTime()<<8|counter)
+}
+
+// ConcurrentAccess reports that Access can be called under the shared lock.
+func (p lfu) ConcurrentAccess() bool {
+	return true
+}
@@ -0,0 +1,82 @@
+package inmemory
+
+import (
+	"testing"
+)
+
+func TestLFULogIncr(t *testing.T) {
+	var counter uint32 = lfuInitValue
+
+	for i := 0; i < 1000; i++ {
+		counter = lfuLogIncr(counter)
+	}
+
+	// the counter grows logarithmically, so it has to be far from 1000
+	if counter <= lfuInitValue || counter > 50 {
+		t.Errorf("Expected counter in range (%d, 50] after 1000 increments, got: %d", lfuInitValue, counter)
+	}
+
+	if counter := lfuLogIncr(lfuMaxValue); counter != lfuMaxValue {
+		t.Errorf("Expected counter to stop at %d, got: %d", lfuMaxValue, counter)
+	}
+}
+
+func TestLFUDecr(t *testing.T) {
+	item := &Item{}
+
+	// counter was last decremented 3 minutes ago
+	item.lfu = (lfuTime()-3)&0xffff<<8 | 10
+	if counter := lfuDecr(item); counter != 7 {
+		t.Errorf("Expected counter: 7, got: %d", counter)
+	}
+
+	// counter can't go below zero
+	item.lfu = (lfuTime()-30)&0xffff<<8 | 10
+	if counter := lfuDecr(item); counter != 0 {
+		t.Errorf("Expected counter: 0, got: %d", counter)
+	}
+
+	// clock wraps around 16 bits
+	if elapsed := lfuElapsed((lfuTime() + 1) & 0xffff); elapsed != 0xffff {
+		t.Errorf("Expected elapsed: %d, got: %d", 0xffff, elapsed)
+	}
+}
+
+func TestObject(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-lfu")
+
+	client.Exec("set", []string{"x", "15"})
+
+	if reply, err := client.Exec("object", []string{"freq", "x"}); reply != "5" || err != nil {
+		t.Errorf("Expected reply: \"5\", got: \"%s\", %#v", reply, err)
+	}
+
+	client.Exec("get", []string{"x"})
+
+	if reply, err := client.Exec("object", []string{"FREQ", "x"}); reply != "6" || err != nil {
+		t.Errorf("Expected reply: \"6\", got: \"%s\", %#v", reply, err)
+	}
+
+	errorCases := []struct {
+		name          string
+		args          []string
+		expectedError error
+	}{
+		{"nonexistent key", []string{"freq", "y"}, ErrNoItem},
+		{"wrong subcommand", []string{"encoding", "x"}, errNoSubcommand},
+		{"1 argument", []string{"freq"}, errArgumentNumber},
+	}
+	for _, tc := range errorCases {
+		if _, err := client.Exec("object", tc.args); err != tc.expectedError {
+			t.Errorf("%s: expected error: %#v, got: %#v", tc.name, tc.expectedError, err)
+		}
+	}
+
+	// frequency is tracked only by LFU policies
+	client = setupTestClient()
+	client.Exec("set", []string{"x", "15"})
+
+	if _, err := client.Exec("object", []string{"freq", "x"}); err != ErrNotLFU {
+		t.Errorf("Expected error: %#v, got: %#v", ErrNotLFU, err)
+	}
+}
@@ -0,0 +1,236 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrLoadTimeout is returned by GetOrLoad if the loader didn't return
+// the value within the load timeout.
+var ErrLoadTimeout = errors.New("load timed out")
+
+// Loader loads the value of the key missing in the data store,
+// e.g. from the database. It has to return ErrNoItem if the key doesn't
+// exist. The context is cancelled when the load timeout is exceeded.
+type Loader interface {
+	Load(ctx context.Context, key string) (string, error)
+}
+
+// LoaderFunc is the function used as Loader.
+type LoaderFunc func(ctx context.Context, key string) (string, error)
+
+// Load calls the function.
+func (loader LoaderFunc) Load(ctx context.Context, key string) (string, error) {
+	return loader(ctx, key)
+}
+
+// loads tracks the running loads and the keys not found by the loader.
+// Concurrent GetOrLoad calls for the same key share one load.
+type loads struct {
+	sync.Mutex
+	calls map[string]*loadCall
+	// missing holds expiration time in nanoseconds of the negative cache entries
+	missing map[string]int64
+}
+
+// loadCall is the single load of the key, done is closed when it's finished.
+type loadCall struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+func newLoads() *loads {
+	return &loads{
+		calls:   make(map[string]*loadCall),
+		missing: make(map[string]int64),
+	}
+}
+
+// isMissing reports whether the key wasn't found by the loader recently.
+func (loads *loads) isMissing(key string, now int64) bool {
+	loads.Lock()
+	defer loads.Unlock()
+
+	expire, ok := loads.missing[key]
+	if ok && expire < now {
+		delete(loads.missing, key)
+		return false
+	}
+	return ok
+}
+
+// removeExpired removes the negative cache entries with exceeded ttl.
+func (loads *loads) removeExpired(now int64) {
+	loads.Lock()
+	defer loads.Unlock()
+
+	for key, expire := range loads.missing {
+		if expire < now {
+			delete(loads.missing, key)
+		}
+	}
+}
+
+// GetOrLoad retrieves string value by given key. If there is no such item,
+// the value is fetched by the loader and stored in the data store.
+// Concurrent calls for the same key wait for the single load.
+// ErrNoItem is returned if the loader didn't find the key.
+func (dataStore *DataStore) GetOrLoad(key string) (string, error) {
+
+	if dataStore.config.loader == nil {
+		return "", errNoLoader
+	}
+
+	shard := dataStore.shard(key)
+	shard.lockAccess()
+
+	item, ok := shard.lookup(key)
+	if ok {
+		value, ok := item.Value.(string)
+		if !ok {
+			shard.unlockAccess()
+			return "", ErrNotString
+		}
+		shard.touch(key, item)
+		expire := item.expire
+		shard.unlockAccess()
+
+		// reload the value in background, if it's about to expire
+		window := dataStore.config.refreshAhead
+		if window > 0 && expire != 0 && time.Until(time.Unix(expire, 0)) <= window {
+			dataStore.load(key, item)
+		}
+		return value, nil
+	}
+	shard.unlockAccess()
+
+	if dataStore.loads.isMissing(key, time.Now().UnixNano()) {
+		return "", ErrNoItem
+	}
+
+	return dataStore.wait(dataStore.load(key, nil))
+}
+
+// load starts loading of the key, unless it's already loading.
+// The loaded value replaces the current item, if it wasn't changed meanwhile.
+func (dataStore *DataStore) load(key string, current *Item) *loadCall {
+
+	loads := dataStore.loads
+
+	loads.Lock()
+	if call, ok := loads.calls[key]; ok {
+		loads.Unlock()
+		return call
+	}
+	call := &loadCall{done: make(chan struct{})}
+	loads.calls[key] = call
+
+	// Close waits for the running loads, the loads started after it
+	// don't store their values
+	closed := false
+	select {
+	case <-dataStore.done:
+		closed = true
+	default:
+		dataStore.background.Add(1)
+	}
+	loads.Unlock()
+
+	go func() {
+		if !closed {
+			defer dataStore.background.Done()
+		}
+
+		// Close cancels the running loads
+		closing, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-dataStore.done:
+				cancel()
+			case <-closing.Done():
+			}
+		}()
+
+		ctx := closing
+		if timeout := dataStore.config.loadTimeout; timeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+			defer cancelTimeout()
+		}
+
+		call.value, call.err = dataStore.config.loader.Load(ctx, key)
+		if call.err == nil && !closed {
+			dataStore.store(key, call.value, current)
+		}
+
+		loads.Lock()
+		delete(loads.calls, key)
+		if call.err == ErrNoItem && dataStore.config.negativeTTL > 0 {
+			loads.missing[key] = time.Now().Add(dataStore.config.negativeTTL).UnixNano()
+		}
+		loads.Unlock()
+
+		close(call.done)
+	}()
+
+	return call
+}
+
+// store sets the loaded value, if the item by given key is still the current one.
+// The value is written like the one set by SET, so it's logged to the
+// append-only file, passed to the backing store and counted as the change.
+func (dataStore *DataStore) store(key, value string, current *Item) {
+
+	// the value is still returned to the callers
+	if dataStore.outOfMemory() {
+		return
+	}
+
+	expire, err := expireAt(dataStore.config.loadTTL)
+	if err != nil {
+		return
+	}
+
+	shard := dataStore.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	// the value was set by another command during the load
+	if item, _ := shard.get(key); item != current {
+		return
+	}
+
+	if err := dataStore.write(key, func() interface{} { return value }, "SET", key, value, strconv.FormatInt(expire, 10)); err != nil {
+		return
+	}
+
+	shard.set(key, &Item{
+		Value:  value,
+		expire: expire,
+	})
+}
+
+// wait returns the result of the load, waiting no longer than the load timeout.
+func (dataStore *DataStore) wait(call *loadCall) (string, error) {
+
+	timeout := dataStore.config.loadTimeout
+	if timeout == 0 {
+		<-call.done
+		return call.value, call.err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-timer.C:
+		return "", ErrLoadTimeout
+	}
+}
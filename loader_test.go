@@ -0,0 +1,259 @@
+package inmemory
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingLoader returns "value of key" and counts the loads.
+// The loads are blocked until release is closed.
+type countingLoader struct {
+	loads   int32
+	release chan struct{}
+}
+
+func (loader *countingLoader) Load(ctx context.Context, key string) (string, error) {
+	atomic.AddInt32(&loader.loads, 1)
+	if loader.release != nil {
+		<-loader.release
+	}
+	if key == "missing" {
+		return "", ErrNoItem
+	}
+	return "value of " + key, nil
+}
+
+func setupLoaderStore(t *testing.T, loader Loader, options ...Option) *DataStore {
+	options = append([]Option{WithWorkers(NoWorkers), WithLoader(loader, NoExpiration)}, options...)
+	dataStore, err := NewWithOptions(options...)
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	t.Cleanup(func() { dataStore.Close() })
+	return dataStore
+}
+
+func TestGetOrLoad(t *testing.T) {
+	loader := &countingLoader{}
+	dataStore := setupLoaderStore(t, loader)
+
+	value, err := dataStore.GetOrLoad("key")
+	if err != nil || value != "value of key" {
+		t.Errorf("Expected loaded value, got: %q, %v", value, err)
+	}
+	if value, err := dataStore.Get("key"); err != nil || value != "value of key" {
+		t.Errorf("Expected loaded value to be stored, got: %q, %v", value, err)
+	}
+
+	// the stored value is returned without load
+	dataStore.Set("other", "stored", NoExpiration)
+	if value, _ := dataStore.GetOrLoad("other"); value != "stored" {
+		t.Errorf("Expected stored value, got: %q", value)
+	}
+	if loads := atomic.LoadInt32(&loader.loads); loads != 1 {
+		t.Errorf("Expected 1 load, got: %d", loads)
+	}
+
+	dataStore.LPush("list", "a")
+	if _, err := dataStore.GetOrLoad("list"); err != ErrNotString {
+		t.Errorf("Expected %v, got: %v", ErrNotString, err)
+	}
+
+	if _, err := setupTestStore(t).GetOrLoad("key"); err != errNoLoader {
+		t.Errorf("Expected %v, got: %v", errNoLoader, err)
+	}
+}
+
+func TestGetOrLoadDeduplication(t *testing.T) {
+	loader := &countingLoader{release: make(chan struct{})}
+	dataStore := setupLoaderStore(t, loader)
+
+	var wg sync.WaitGroup
+	results := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, _ := dataStore.GetOrLoad("key")
+			results <- value
+		}()
+	}
+
+	// let all the callers wait for the load
+	for dataStore.Stats().Misses != 10 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(loader.release)
+	wg.Wait()
+	close(results)
+
+	for value := range results {
+		if value != "value of key" {
+			t.Errorf("Expected loaded value, got: %q", value)
+		}
+	}
+	if loads := atomic.LoadInt32(&loader.loads); loads != 1 {
+		t.Errorf("Expected 1 load, got: %d", loads)
+	}
+}
+
+func TestGetOrLoadTimeout(t *testing.T) {
+	loader := &countingLoader{release: make(chan struct{})}
+	dataStore := setupLoaderStore(t, loader, WithLoadTimeout(10*time.Millisecond))
+
+	if _, err := dataStore.GetOrLoad("key"); err != ErrLoadTimeout {
+		t.Errorf("Expected %v, got: %v", ErrLoadTimeout, err)
+	}
+
+	// the late result is still stored
+	close(loader.release)
+	for {
+		if value, err := dataStore.Get("key"); err == nil {
+			if value != "value of key" {
+				t.Errorf("Expected loaded value, got: %q", value)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetOrLoadNegativeCaching(t *testing.T) {
+	loader := &countingLoader{}
+	dataStore := setupLoaderStore(t, loader, WithNegativeCaching(time.Minute))
+
+	for i := 0; i < 3; i++ {
+		if _, err := dataStore.GetOrLoad("missing"); err != ErrNoItem {
+			t.Errorf("Expected %v, got: %v", ErrNoItem, err)
+		}
+	}
+	if loads := atomic.LoadInt32(&loader.loads); loads != 1 {
+		t.Errorf("Expected 1 load, got: %d", loads)
+	}
+
+	// expired entries are loaded again
+	dataStore.loads.removeExpired(time.Now().Add(2 * time.Minute).UnixNano())
+	dataStore.GetOrLoad("missing")
+	if loads := atomic.LoadInt32(&loader.loads); loads != 2 {
+		t.Errorf("Expected 2 loads, got: %d", loads)
+	}
+}
+
+func TestGetOrLoadRefreshAhead(t *testing.T) {
+	loader := &countingLoader{}
+	dataStore := setupLoaderStore(t, loader, WithRefreshAhead(time.Minute))
+
+	dataStore.Set("key", "old", 30*time.Second)
+
+	// the current value is returned, while the new one is loaded
+	if value, _ := dataStore.GetOrLoad("key"); value != "old" {
+		t.Errorf("Expected old value, got: %q", value)
+	}
+	for {
+		if value, _ := dataStore.Get("key"); value == "value of key" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the values far from the expiration are not refreshed
+	dataStore.Set("key", "new", time.Hour)
+	dataStore.GetOrLoad("key")
+	if loads := atomic.LoadInt32(&loader.loads); loads != 1 {
+		t.Errorf("Expected 1 load, got: %d", loads)
+	}
+}
+
+func TestLoadDoesntOverwrite(t *testing.T) {
+	loader := &countingLoader{release: make(chan struct{})}
+	dataStore := setupLoaderStore(t, loader)
+
+	done := make(chan struct{})
+	go func() {
+		dataStore.GetOrLoad("key")
+		close(done)
+	}()
+	for dataStore.Stats().Misses != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the value set during the load is newer than the loaded one
+	dataStore.Set("key", "set", NoExpiration)
+	close(loader.release)
+	<-done
+
+	if value, _ := dataStore.Get("key"); value != "set" {
+		t.Errorf("Expected value set during the load, got: %q", value)
+	}
+}
+
+func TestLoadedValueWritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.aof")
+	store := &recordingStore{}
+	dataStore := setupLoaderStore(t, &countingLoader{}, WithAppendOnly(path, FsyncAlways), WithWriteThrough(store))
+
+	if _, err := dataStore.GetOrLoad("key"); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if value := store.values()["key"]; value != "value of key" {
+		t.Errorf("Expected loaded value in the backing store, got: %v", value)
+	}
+	if changes := atomic.LoadInt64(&dataStore.counters.changes); changes != 1 {
+		t.Errorf("Expected 1 change, got: %d", changes)
+	}
+	dataStore.Close()
+
+	// the loaded value is replayed from the append-only file
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithAppendOnly(path, FsyncAlways))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	if value, err := dataStore.Get("key"); err != nil || value != "value of key" {
+		t.Errorf("Expected replayed loaded value, got: %q, %v", value, err)
+	}
+}
+
+func TestCloseCancelsLoads(t *testing.T) {
+	started := make(chan struct{})
+	dataStore := setupLoaderStore(t, LoaderFunc(func(ctx context.Context, key string) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+
+	loaded := make(chan error)
+	go func() {
+		_, err := dataStore.GetOrLoad("key")
+		loaded <- err
+	}()
+	<-started
+
+	// Close waits for the cancelled load
+	dataStore.Close()
+	dataStore.loads.Lock()
+	running := len(dataStore.loads.calls)
+	dataStore.loads.Unlock()
+	if running != 0 {
+		t.Errorf("Expected no running loads after close, got: %d", running)
+	}
+	if err := <-loaded; err != context.Canceled {
+		t.Errorf("Expected %v, got: %v", context.Canceled, err)
+	}
+
+	// the values loaded after close are not stored
+	dataStore = setupLoaderStore(t, &countingLoader{})
+	dataStore.Close()
+	if value, err := dataStore.GetOrLoad("key"); err != nil || value != "value of key" {
+		t.Errorf("Expected loaded value, got: %q, %v", value, err)
+	}
+	if _, err := dataStore.Get("key"); err != ErrNoItem {
+		t.Errorf("Expected %v, got: %v", ErrNoItem, err)
+	}
+}
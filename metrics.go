@@ -0,0 +1,215 @@
+package inmemory
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsWriter writes its metrics in the Prometheus text format.
+type MetricsWriter interface {
+	WriteMetrics(w io.Writer) error
+}
+
+// MetricsHandler returns the HTTP handler serving the metrics of the writers
+// in the Prometheus text format, e.g. for the /metrics path.
+func MetricsHandler(writers ...MetricsWriter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, writer := range writers {
+			if err := writer.WriteMetrics(w); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// metrics writes the metric families in the Prometheus text format.
+// The first write error is kept and the rest of the writes are skipped.
+type metrics struct {
+	w   io.Writer
+	err error
+}
+
+// family writes HELP and TYPE lines of the metric.
+func (m *metrics) family(name, kind, help string) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes the value of the metric, labels are name and value pairs.
+func (m *metrics) sample(name string, value float64, labels ...string) {
+	if len(labels) == 0 {
+		m.printf("%s %s\n", name, formatFloat(value))
+		return
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"="+strconv.Quote(labels[i+1]))
+	}
+	m.printf("%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+// single writes the metric family with the single value.
+func (m *metrics) single(name, kind, help string, value float64) {
+	m.family(name, kind, help)
+	m.sample(name, value)
+}
+
+func (m *metrics) printf(format string, args ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// latencyBuckets are the upper bounds of the command duration histogram in seconds.
+var latencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// commandMetric holds the number of calls and the duration histogram of the command.
+type commandMetric struct {
+	calls  uint64
+	errors uint64
+	// nanoseconds is the total duration of the calls
+	nanoseconds uint64
+	// buckets count the calls by the latency bucket, the last one is +Inf
+	buckets []uint64
+}
+
+// CommandMetrics counts the calls of the commands and their duration.
+// Its methods are safe for the concurrent use.
+type CommandMetrics struct {
+	mu       sync.RWMutex
+	commands map[string]*commandMetric
+}
+
+// NewCommandMetrics creates the metrics of the commands.
+func NewCommandMetrics() *CommandMetrics {
+	return &CommandMetrics{
+		commands: make(map[string]*commandMetric),
+	}
+}
+
+// Observe counts the call of the command with the given duration.
+// The commands which are not in the command table are counted as "UNKNOWN",
+// so the wrong input doesn't create new metrics.
+func (cm *CommandMetrics) Observe(command string, duration time.Duration, failed bool) {
+	if _, ok := commands[command]; !ok {
+		command = "UNKNOWN"
+	}
+
+	cm.mu.RLock()
+	metric, ok := cm.commands[command]
+	cm.mu.RUnlock()
+
+	if !ok {
+		cm.mu.Lock()
+		if metric, ok = cm.commands[command]; !ok {
+			metric = &commandMetric{buckets: make([]uint64, len(latencyBuckets)+1)}
+			cm.commands[command] = metric
+		}
+		cm.mu.Unlock()
+	}
+
+	atomic.AddUint64(&metric.calls, 1)
+	if failed {
+		atomic.AddUint64(&metric.errors, 1)
+	}
+	atomic.AddUint64(&metric.nanoseconds, uint64(duration))
+
+	bucket := sort.SearchFloat64s(latencyBuckets, duration.Seconds())
+	atomic.AddUint64(&metric.buckets[bucket], 1)
+}
+
+// WriteMetrics writes the command metrics in the Prometheus text format.
+func (cm *CommandMetrics) WriteMetrics(w io.Writer) error {
+	cm.mu.RLock()
+	names := make([]string, 0, len(cm.commands))
+	for name := range cm.commands {
+		names = append(names, name)
+	}
+	cm.mu.RUnlock()
+	sort.Strings(names)
+
+	m := &metrics{w: w}
+
+	m.family("inmemory_commands_total", "counter", "Number of the executed commands.")
+	for _, name := range names {
+		m.sample("inmemory_commands_total", float64(atomic.LoadUint64(&cm.metric(name).calls)), "command", name)
+	}
+
+	m.family("inmemory_command_errors_total", "counter", "Number of the commands which returned error.")
+	for _, name := range names {
+		m.sample("inmemory_command_errors_total", float64(atomic.LoadUint64(&cm.metric(name).errors)), "command", name)
+	}
+
+	m.family("inmemory_command_duration_seconds", "histogram", "Duration of the commands.")
+	for _, name := range names {
+		metric := cm.metric(name)
+
+		var count uint64
+		for i, bound := range latencyBuckets {
+			count += atomic.LoadUint64(&metric.buckets[i])
+			m.sample("inmemory_command_duration_seconds_bucket", float64(count), "command", name, "le", formatFloat(bound))
+		}
+		count += atomic.LoadUint64(&metric.buckets[len(latencyBuckets)])
+		m.sample("inmemory_command_duration_seconds_bucket", float64(count), "command", name, "le", "+Inf")
+
+		seconds := time.Duration(atomic.LoadUint64(&metric.nanoseconds)).Seconds()
+		m.sample("inmemory_command_duration_seconds_sum", seconds, "command", name)
+		m.sample("inmemory_command_duration_seconds_count", float64(count), "command", name)
+	}
+
+	return m.err
+}
+
+func (cm *CommandMetrics) metric(name string) *commandMetric {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	return cm.commands[name]
+}
+
+// WriteMetrics writes the metrics of the data store and its commands
+// in the Prometheus text format.
+func (dataStore *DataStore) WriteMetrics(w io.Writer) error {
+	if err := dataStore.commandMetrics.WriteMetrics(w); err != nil {
+		return err
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	dataStore.rlockAll()
+	keys, expires := 0, 0
+	for _, shard := range dataStore.shards {
+		keys += len(shard.values)
+		expires += len(shard.expires)
+	}
+	dataStore.runlockAll()
+
+	// the counters aren't reset by RESETSTAT
+	stats := dataStore.totals()
+
+	m := &metrics{w: w}
+	m.single("inmemory_keys", "gauge", "Number of the keys.", float64(keys))
+	m.single("inmemory_expiring_keys", "gauge", "Number of the keys with ttl.", float64(expires))
+	m.single("inmemory_memory_used_bytes", "gauge", "Allocated heap memory.", float64(memStats.Alloc))
+	m.single("inmemory_memory_max_bytes", "gauge", "Max heap memory, the keys are evicted above it.", float64(dataStore.config.maxMemory))
+	m.single("inmemory_keyspace_hits_total", "counter", "Number of the lookups which found the key.", float64(stats.Hits))
+	m.single("inmemory_keyspace_misses_total", "counter", "Number of the lookups which didn't find the key.", float64(stats.Misses))
+	m.single("inmemory_expired_keys_total", "counter", "Number of the keys removed because of ttl.", float64(stats.Expired))
+	m.single("inmemory_evicted_keys_total", "counter", "Number of the keys removed by the eviction policy.", float64(stats.Evicted))
+	m.single("inmemory_connected_clients", "gauge", "Number of the connected clients.", float64(dataStore.clients.len()))
+	return m.err
+}
@@ -0,0 +1,122 @@
+package inmemory
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandMetrics(t *testing.T) {
+	metrics := NewCommandMetrics()
+	metrics.Observe("GET", 20*time.Microsecond, false)
+	metrics.Observe("GET", 2*time.Second, true)
+	metrics.Observe("WRONG", time.Microsecond, true)
+
+	var buf bytes.Buffer
+	if err := metrics.WriteMetrics(&buf); err != nil {
+		t.Fatalf("WriteMetrics failed: %v", err)
+	}
+	output := buf.String()
+
+	expected := []string{
+		"# TYPE inmemory_commands_total counter",
+		`inmemory_commands_total{command="GET"} 2`,
+		`inmemory_commands_total{command="UNKNOWN"} 1`,
+		`inmemory_command_errors_total{command="GET"} 1`,
+		"# TYPE inmemory_command_duration_seconds histogram",
+		`inmemory_command_duration_seconds_bucket{command="GET",le="1e-05"} 0`,
+		`inmemory_command_duration_seconds_bucket{command="GET",le="5e-05"} 1`,
+		`inmemory_command_duration_seconds_bucket{command="GET",le="1"} 1`,
+		`inmemory_command_duration_seconds_bucket{command="GET",le="+Inf"} 2`,
+		`inmemory_command_duration_seconds_sum{command="GET"} 2.00002`,
+		`inmemory_command_duration_seconds_count{command="GET"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("Expected line %q in the metrics:\n%s", line, output)
+		}
+	}
+}
+
+func TestDataStoreMetrics(t *testing.T) {
+	client := setupTestClient()
+	defer client.ds.Close()
+
+	client.Exec("SET", []string{"key", "value"})
+	client.Exec("GET", []string{"key"})
+	client.Exec("GET", []string{"missing"})
+
+	// the counters don't go back on RESETSTAT
+	client.Exec("CONFIG", []string{"RESETSTAT"})
+	if stats := client.ds.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("Expected reset stats, got: %+v", stats)
+	}
+
+	server := httptest.NewServer(MetricsHandler(client.ds))
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Couldn't get metrics: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	output := string(body)
+
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Expected text content type, got: %q", contentType)
+	}
+	expected := []string{
+		`inmemory_commands_total{command="GET"} 2`,
+		`inmemory_commands_total{command="SET"} 1`,
+		`inmemory_command_errors_total{command="GET"} 1`,
+		"inmemory_keys 1",
+		"inmemory_expiring_keys 1",
+		"inmemory_keyspace_hits_total 1",
+		"inmemory_keyspace_misses_total 1",
+		"inmemory_evicted_keys_total 0",
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("Expected line %q in the metrics:\n%s", line, output)
+		}
+	}
+}
+
+func TestPoolAndCircleMetrics(t *testing.T) {
+	first, second := &Server{"server1", 2}, &Server{"server2", 3}
+
+	pool := NewPool(10, nil, first, second)
+	pool.Get("server1")
+
+	circle := NewCircle()
+	circle.Adjust(first, second)
+
+	var buf bytes.Buffer
+	if err := pool.WriteMetrics(&buf); err != nil {
+		t.Fatalf("WriteMetrics failed: %v", err)
+	}
+	if err := circle.WriteMetrics(&buf); err != nil {
+		t.Fatalf("WriteMetrics failed: %v", err)
+	}
+	output := buf.String()
+
+	expected := []string{
+		`inmemory_pool_capacity{backend="server1"} 10`,
+		`inmemory_pool_idle_connections{backend="server2"} 0`,
+		`inmemory_pool_gets_total{backend="server1"} 1`,
+		`inmemory_pool_get_errors_total{backend="server1"} 1`,
+		`inmemory_pool_gets_total{backend="server2"} 0`,
+		"inmemory_ring_servers 2",
+		`inmemory_ring_vnodes{server="server1"} 2`,
+		`inmemory_ring_vnodes{server="server2"} 3`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("Expected line %q in the metrics:\n%s", line, output)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package inmemory
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// number of the commands buffered for the slow monitor before they're dropped
+const monitorBuffer = 1024
+
+// Monitor receives the live feed of the commands executed by the clients
+// of the data store. The monitor has to be closed with Close.
+type Monitor struct {
+	dataStore *DataStore
+	feed      chan string
+	dropped   uint64
+}
+
+// monitors are the monitors attached to the data store.
+type monitors struct {
+	// count is the number of the monitors, the commands aren't formatted without them
+	count int32
+
+	sync.RWMutex
+	set map[*Monitor]struct{}
+}
+
+func newMonitors() *monitors {
+	return &monitors{
+		set: make(map[*Monitor]struct{}),
+	}
+}
+
+// Monitor attaches new monitor to the data store.
+func (dataStore *DataStore) Monitor() *Monitor {
+	monitor := &Monitor{
+		dataStore: dataStore,
+		feed:      make(chan string, monitorBuffer),
+	}
+
+	monitors := dataStore.monitors
+	monitors.Lock()
+	defer monitors.Unlock()
+
+	monitors.set[monitor] = struct{}{}
+	atomic.AddInt32(&monitors.count, 1)
+	return monitor
+}
+
+// Feed returns the channel of the executed commands in the format of
+// Redis MONITOR: unix time with microseconds, database and client address
+// in brackets, the quoted command and arguments, e.g.
+//
+//	1539000000.123456 [0 127.0.0.1:5000] "SET" "key" "value"
+//
+// The database is always 0, as the data store has the single keyspace.
+// The commands of the embedded clients have "local" address.
+// The channel is closed by Close.
+func (monitor *Monitor) Feed() <-chan string {
+	return monitor.feed
+}
+
+// Dropped returns the number of the commands which were dropped because
+// the feed wasn't read fast enough.
+func (monitor *Monitor) Dropped() uint64 {
+	return atomic.LoadUint64(&monitor.dropped)
+}
+
+// Close detaches the monitor from the data store. Calling Close again has no effect.
+func (monitor *Monitor) Close() {
+	monitors := monitor.dataStore.monitors
+	monitors.Lock()
+	defer monitors.Unlock()
+
+	if _, ok := monitors.set[monitor]; !ok {
+		return
+	}
+	delete(monitors.set, monitor)
+	atomic.AddInt32(&monitors.count, -1)
+	close(monitor.feed)
+}
+
+// observe sends the command to the monitors. The commands are never
+// waiting for the monitors, the slow ones miss the commands.
+func (monitors *monitors) observe(client *Client, command string, args []string) {
+	if atomic.LoadInt32(&monitors.count) == 0 {
+		return
+	}
+
+	now := time.Now()
+	addr := client.addr
+	if addr == "" {
+		addr = "local"
+	}
+
+	var line strings.Builder
+	line.WriteString(strconv.FormatInt(now.Unix(), 10))
+	line.WriteString(".")
+	micros := strconv.Itoa(now.Nanosecond() / 1000)
+	line.WriteString(strings.Repeat("0", 6-len(micros)) + micros)
+	line.WriteString(" [0 " + addr + "] ")
+	line.WriteString(strconv.Quote(command))
+	for _, arg := range args {
+		line.WriteString(" " + strconv.Quote(arg))
+	}
+
+	monitors.RLock()
+	defer monitors.RUnlock()
+
+	for monitor := range monitors.set {
+		select {
+		case monitor.feed <- line.String():
+		default:
+			atomic.AddUint64(&monitor.dropped, 1)
+		}
+	}
+}
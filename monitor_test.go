@@ -0,0 +1,54 @@
+package inmemory
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	dataStore := setupTestStore(t)
+
+	// the commands before the monitor is attached are not fed
+	client := NewRemoteClient(dataStore, "127.0.0.1:5000")
+	client.Exec("SET", []string{"before", "value"})
+
+	monitor := dataStore.Monitor()
+	client.Exec("set", []string{"key", "the \"value\""})
+	NewClient(dataStore).Exec("GET", []string{"key"})
+	client.Exec("WRONG", nil)
+	monitor.Close()
+	monitor.Close()
+
+	expected := []*regexp.Regexp{
+		regexp.MustCompile(`^\d+\.\d{6} \[0 127\.0\.0\.1:5000\] "SET" "key" "the \\"value\\""$`),
+		regexp.MustCompile(`^\d+\.\d{6} \[0 local\] "GET" "key"$`),
+	}
+
+	var lines []string
+	for line := range monitor.Feed() {
+		lines = append(lines, line)
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d commands, got: %v", len(expected), lines)
+	}
+	for i, line := range lines {
+		if !expected[i].MatchString(line) {
+			t.Errorf("Expected line matching %s, got: %q", expected[i], line)
+		}
+	}
+}
+
+func TestMonitorDrops(t *testing.T) {
+	dataStore := setupTestStore(t)
+
+	monitor := dataStore.Monitor()
+	defer monitor.Close()
+
+	client := NewClient(dataStore)
+	for i := 0; i < monitorBuffer+10; i++ {
+		client.Exec("SIZE", nil)
+	}
+	if dropped := monitor.Dropped(); dropped != 10 {
+		t.Errorf("Expected 10 dropped commands, got: %d", dropped)
+	}
+}
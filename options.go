@@ -0,0 +1,408 @@
+package inmemory
+
+import (
+	"errors"
+	"time"
+)
+
+// Workers is the set of background workers of the data store.
+type Workers uint
+
+// Background workers which can be started for the data store.
+const (
+	// TTLWorker removes the items with exceeded ttl.
+	TTLWorker Workers = 1 << iota
+	// PersistenceWorker periodically saves the data to the backup directory.
+	PersistenceWorker
+	// MemoryWorker evicts the items when the memory limit is exceeded.
+	MemoryWorker
+
+	// NoWorkers doesn't start any background workers.
+	NoWorkers Workers = 0
+	// AllWorkers starts all background workers.
+	AllWorkers = TTLWorker | PersistenceWorker | MemoryWorker
+)
+
+var (
+	errMaxMemory = errors.New("max memory should be > 0")
+	errInterval  = errors.New("interval should be > 0")
+	errBackupDir = errors.New("backup directory should be set")
+	errRetention = errors.New("number of backups to keep should be > 0")
+	errNoLoader  = errors.New("loader is not set")
+	errTimeout   = errors.New("timeout should be > 0")
+	errNoBacking = errors.New("backing store is not set")
+	errBatchSize = errors.New("batch size should be > 0")
+	errLogSize   = errors.New("slow log size should be >= 0")
+	errNoAOFPath = errors.New("append-only file path should be set")
+	errPercent   = errors.New("percentage should be >= 0")
+	errMinSize   = errors.New("min size should be >= 0")
+	errNoKey     = errors.New("encryption key is not set")
+	errChanges   = errors.New("number of changes should be > 0")
+)
+
+// config holds the settings of the data store. The defaults are taken from
+// the default server configuration.
+type config struct {
+	policy              string
+	shards              int
+	maxMemory           uint64
+	memoryCheckInterval time.Duration
+	cleanupInterval     time.Duration
+	backupDir           string
+	saveRules           []SaveRule
+	backupNumber        int
+	workers             Workers
+	finalSnapshot       bool
+	slowLogThreshold    time.Duration
+	slowLogSize         int
+
+	// settings of GetOrLoad
+	loader       Loader
+	loadTTL      time.Duration
+	loadTimeout  time.Duration
+	negativeTTL  time.Duration
+	refreshAhead time.Duration
+
+	// settings of the backing store
+	backing       BackingStore
+	writeBehind   bool
+	writeInterval time.Duration
+	writeBatch    int
+
+	// settings of the append-only file
+	appendOnly        string
+	appendFsync       FsyncPolicy
+	aofRewritePercent int
+	aofRewriteMinSize int64
+
+	// settings of the snapshots and the append-only file on the disk
+	compress   bool
+	encryption *keyRing
+
+	// settings of the restore on creation
+	restore         bool
+	restoreRequired bool
+}
+
+func defaultConfig() config {
+	return config{
+		policy:              defaultPolicy,
+		shards:              defaultShards,
+		maxMemory:           uint64(maxMemory),
+		memoryCheckInterval: time.Duration(memoryCheckInterval) * time.Second,
+		cleanupInterval:     cleanupInterval,
+		backupDir:           backupDir,
+		saveRules:           defaultSaveRules,
+		backupNumber:        backupNumber,
+		workers:             AllWorkers,
+		slowLogThreshold:    slowLogThreshold,
+		slowLogSize:         slowLogSize,
+		aofRewritePercent:   aofRewritePercent,
+		aofRewriteMinSize:   aofRewriteMinSize,
+	}
+}
+
+// newConfig applies the options to the default configuration.
+func newConfig(options []Option) (config, error) {
+	config := defaultConfig()
+	for _, option := range options {
+		if err := option(&config); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// Option sets the setting of the data store created by NewWithOptions.
+// It returns error if the setting is not valid.
+type Option func(*config) error
+
+// WithEvictionPolicy sets the eviction policy by its name, e.g. "allkeys-lru".
+func WithEvictionPolicy(policy string) Option {
+	return func(c *config) error {
+		if _, ok := policies[policy]; !ok {
+			return errNoSuchPolicy
+		}
+		c.policy = policy
+		return nil
+	}
+}
+
+// WithShards sets the number of independently locked shards.
+func WithShards(shards int) Option {
+	return func(c *config) error {
+		if shards < 1 {
+			return errShardsNumber
+		}
+		c.shards = shards
+		return nil
+	}
+}
+
+// WithMaxMemory sets the limit of the heap memory in bytes and how often
+// memoryd checks it.
+func WithMaxMemory(bytes uint64, checkInterval time.Duration) Option {
+	return func(c *config) error {
+		if bytes == 0 {
+			return errMaxMemory
+		}
+		if checkInterval <= 0 {
+			return errInterval
+		}
+		c.maxMemory = bytes
+		c.memoryCheckInterval = checkInterval
+		return nil
+	}
+}
+
+// WithCleanupInterval sets how often ttld removes the expired items.
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(c *config) error {
+		if interval <= 0 {
+			return errInterval
+		}
+		c.cleanupInterval = interval
+		return nil
+	}
+}
+
+// WithBackups sets the directory for the backups and how many of the latest
+// backups are kept. When persistenced creates them is set by WithSaveRules.
+func WithBackups(dir string, keep int) Option {
+	return func(c *config) error {
+		if dir == "" {
+			return errBackupDir
+		}
+		if keep < 1 {
+			return errRetention
+		}
+		c.backupDir = dir
+		c.backupNumber = keep
+		return nil
+	}
+}
+
+// WithSaveRules sets when persistenced creates the backups: as soon as
+// any of the rules is satisfied. The default rules save the backup after
+// a change in an hour, 100 changes in 5 minutes or 10000 changes in
+// a minute. No rules disable the periodic backups, Save and
+// BackgroundSave can still be called.
+func WithSaveRules(rules ...SaveRule) Option {
+	return func(c *config) error {
+		for _, rule := range rules {
+			if rule.Interval <= 0 {
+				return errInterval
+			}
+			if rule.Changes < 1 {
+				return errChanges
+			}
+		}
+		c.saveRules = append([]SaveRule(nil), rules...)
+		return nil
+	}
+}
+
+// WithWorkers sets the background workers to start, e.g. TTLWorker|MemoryWorker.
+func WithWorkers(workers Workers) Option {
+	return func(c *config) error {
+		c.workers = workers
+		return nil
+	}
+}
+
+// WithFinalSnapshot makes Close save the data to the backup directory
+// after the workers are stopped.
+func WithFinalSnapshot() Option {
+	return func(c *config) error {
+		c.finalSnapshot = true
+		return nil
+	}
+}
+
+// WithLoader sets the loader used by GetOrLoad to fetch the missing keys.
+// The loaded values are stored with the given ttl, NoExpiration and
+// DefaultExpiration are accepted.
+func WithLoader(loader Loader, ttl time.Duration) Option {
+	return func(c *config) error {
+		if loader == nil {
+			return errNoLoader
+		}
+		if ttl < 0 && ttl != DefaultExpiration {
+			return ErrTTLValue
+		}
+		c.loader = loader
+		c.loadTTL = ttl
+		return nil
+	}
+}
+
+// WithLoadTimeout sets how long GetOrLoad waits for the loader.
+// The loader is given the context with the same deadline.
+func WithLoadTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		if timeout <= 0 {
+			return errTimeout
+		}
+		c.loadTimeout = timeout
+		return nil
+	}
+}
+
+// WithNegativeCaching makes GetOrLoad remember for ttl the keys which
+// the loader didn't find, so they are not loaded again meanwhile.
+func WithNegativeCaching(ttl time.Duration) Option {
+	return func(c *config) error {
+		if ttl <= 0 {
+			return ErrTTLValue
+		}
+		c.negativeTTL = ttl
+		return nil
+	}
+}
+
+// WithRefreshAhead makes GetOrLoad reload the value in background when it
+// expires within the window. The current value is returned meanwhile.
+func WithRefreshAhead(window time.Duration) Option {
+	return func(c *config) error {
+		if window <= 0 {
+			return errInterval
+		}
+		c.refreshAhead = window
+		return nil
+	}
+}
+
+// WithWriteThrough makes the write commands write the changes to the
+// backing store before they are applied to the data store. The command
+// fails if the backing store returns error.
+func WithWriteThrough(store BackingStore) Option {
+	return func(c *config) error {
+		if store == nil {
+			return errNoBacking
+		}
+		c.backing = store
+		c.writeBehind = false
+		return nil
+	}
+}
+
+// WithWriteBehind makes the write commands queue the changes, which are
+// written to the backing store in background with the given interval.
+// The changes of the same key are coalesced, at most batchSize keys are
+// written at once. The failed writes are retried on the next interval.
+func WithWriteBehind(store BackingStore, interval time.Duration, batchSize int) Option {
+	return func(c *config) error {
+		if store == nil {
+			return errNoBacking
+		}
+		if interval <= 0 {
+			return errInterval
+		}
+		if batchSize < 1 {
+			return errBatchSize
+		}
+		c.backing = store
+		c.writeBehind = true
+		c.writeInterval = interval
+		c.writeBatch = batchSize
+		return nil
+	}
+}
+
+// WithSlowLog sets the duration of the commands to be recorded in the slow
+// log and how many of the latest ones are kept. Zero size disables the slow log.
+func WithSlowLog(threshold time.Duration, size int) Option {
+	return func(c *config) error {
+		if size < 0 {
+			return errLogSize
+		}
+		c.slowLogThreshold = threshold
+		c.slowLogSize = size
+		return nil
+	}
+}
+
+// WithAppendOnly makes the write commands log the changes to the append-only
+// file at the path. The file is replayed when the data store is created,
+// so the changes since the last backup are not lost on restart.
+// The policy defines how often the file is synced to the disk.
+func WithAppendOnly(path string, policy FsyncPolicy) Option {
+	return func(c *config) error {
+		if path == "" {
+			return errNoAOFPath
+		}
+		if policy < FsyncEverySec || policy > FsyncNo {
+			return errFsyncPolicy
+		}
+		c.appendOnly = path
+		c.appendFsync = policy
+		return nil
+	}
+}
+
+// WithAppendOnlyRewrite sets when the append-only file is rewritten in
+// background: after it grows by the percentage of its size after the last
+// rewrite, but not before it reaches minSize bytes. Zero percentage disables
+// the automatic rewrite, RewriteAppendOnly can still be called.
+func WithAppendOnlyRewrite(percentage int, minSize int64) Option {
+	return func(c *config) error {
+		if percentage < 0 {
+			return errPercent
+		}
+		if minSize < 0 {
+			return errMinSize
+		}
+		c.aofRewritePercent = percentage
+		c.aofRewriteMinSize = minSize
+		return nil
+	}
+}
+
+// WithCompression makes the snapshots and the rewritten append-only file
+// compressed with gzip. The records appended between the rewrites are
+// not compressed.
+func WithCompression() Option {
+	return func(c *config) error {
+		c.compress = true
+		return nil
+	}
+}
+
+// WithEncryption encrypts the snapshots and the append-only file with
+// the key. The data encrypted with the previous keys can still be read,
+// so the key can be rotated: the next snapshot and the next rewrite of
+// the append-only file use the new key, after that the old one isn't needed.
+func WithEncryption(key *EncryptionKey, previous ...*EncryptionKey) Option {
+	return func(c *config) error {
+		if key == nil {
+			return errNoKey
+		}
+		ring := &keyRing{current: key, all: []*EncryptionKey{key}}
+		for _, k := range previous {
+			if k != nil {
+				ring.all = append(ring.all, k)
+			}
+		}
+		c.encryption = ring
+		return nil
+	}
+}
+
+// WithRestore restores the data when the data store is created. The
+// append-only file is replayed if it's enabled and not empty, otherwise the
+// newest valid backup of the backup directory is restored, falling back to
+// the older ones if it's corrupted. If required is set, the data store isn't
+// created when the data can't be restored, otherwise it starts empty and the
+// corrupted append-only file is moved aside. No backups and no append-only
+// file aren't an error, the data store starts empty.
+// The append-only file is looked for only at the path of WithAppendOnly,
+// it isn't searched for in the backup directory. Without WithAppendOnly the
+// newest backup is restored, even if the append-only file of the previous
+// run holds newer data.
+func WithRestore(required bool) Option {
+	return func(c *config) error {
+		c.restore = true
+		c.restoreRequired = required
+		return nil
+	}
+}
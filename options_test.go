@@ -0,0 +1,158 @@
+package inmemory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	dataStore, err := NewWithOptions(
+		WithEvictionPolicy("allkeys-lfu"),
+		WithShards(2),
+		WithMaxMemory(1<<30, time.Second),
+		WithCleanupInterval(time.Second),
+		WithSaveRules(SaveRule{time.Minute, 10}),
+		WithBackups(dir, 3),
+		WithWorkers(TTLWorker|MemoryWorker),
+	)
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	expected := config{
+		policy:              "allkeys-lfu",
+		shards:              2,
+		maxMemory:           1 << 30,
+		memoryCheckInterval: time.Second,
+		cleanupInterval:     time.Second,
+		backupDir:           dir,
+		saveRules:           []SaveRule{{time.Minute, 10}},
+		backupNumber:        3,
+		workers:             TTLWorker | MemoryWorker,
+		slowLogThreshold:    slowLogThreshold,
+		slowLogSize:         slowLogSize,
+		aofRewritePercent:   aofRewritePercent,
+		aofRewriteMinSize:   aofRewriteMinSize,
+	}
+	if !reflect.DeepEqual(dataStore.config, expected) {
+		t.Errorf("Expected config: %+v, got: %+v", expected, dataStore.config)
+	}
+	if len(dataStore.shards) != 2 {
+		t.Errorf("Expected 2 shards, got: %d", len(dataStore.shards))
+	}
+
+	errorCases := []struct {
+		name          string
+		option        Option
+		expectedError error
+	}{
+		{"wrong policy", WithEvictionPolicy("wrong-policy"), errNoSuchPolicy},
+		{"0 shards", WithShards(0), errShardsNumber},
+		{"0 max memory", WithMaxMemory(0, time.Second), errMaxMemory},
+		{"0 memory check interval", WithMaxMemory(1, 0), errInterval},
+		{"0 cleanup interval", WithCleanupInterval(0), errInterval},
+		{"empty backup dir", WithBackups("", 1), errBackupDir},
+		{"0 backups to keep", WithBackups(dir, 0), errRetention},
+		{"0 save interval", WithSaveRules(SaveRule{0, 1}), errInterval},
+		{"0 save changes", WithSaveRules(SaveRule{time.Minute, 0}), errChanges},
+		{"nil loader", WithLoader(nil, NoExpiration), errNoLoader},
+		{"negative load ttl", WithLoader(&countingLoader{}, -time.Second), ErrTTLValue},
+		{"0 load timeout", WithLoadTimeout(0), errTimeout},
+		{"0 negative caching ttl", WithNegativeCaching(0), ErrTTLValue},
+		{"0 refresh ahead window", WithRefreshAhead(0), errInterval},
+		{"negative slow log size", WithSlowLog(time.Millisecond, -1), errLogSize},
+		{"nil write-through store", WithWriteThrough(nil), errNoBacking},
+		{"nil write-behind store", WithWriteBehind(nil, time.Second, 1), errNoBacking},
+		{"0 write-behind interval", WithWriteBehind(&recordingStore{}, 0, 1), errInterval},
+		{"0 write-behind batch", WithWriteBehind(&recordingStore{}, time.Second, 0), errBatchSize},
+		{"empty append-only path", WithAppendOnly("", FsyncAlways), errNoAOFPath},
+		{"unknown fsync policy", WithAppendOnly("data.aof", FsyncPolicy(10)), errFsyncPolicy},
+		{"negative rewrite percentage", WithAppendOnlyRewrite(-1, 0), errPercent},
+		{"negative rewrite min size", WithAppendOnlyRewrite(100, -1), errMinSize},
+		{"nil encryption key", WithEncryption(nil), errNoKey},
+	}
+	for _, tc := range errorCases {
+		if _, err := NewWithOptions(tc.option); err != tc.expectedError {
+			t.Errorf("%s: expected error: %#v, got: %#v", tc.name, tc.expectedError, err)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	dataStore, err := NewWithOptions(WithBackups(t.TempDir(), 1))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+
+	if err := dataStore.Close(); err != nil {
+		t.Errorf("Expected error: <nil>, got: %#v", err)
+	}
+	// closing again has no effect
+	if err := dataStore.Close(); err != nil {
+		t.Errorf("Expected error: <nil>, got: %#v", err)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected workers to stop, got %d goroutines, was %d", after, before)
+	}
+
+	// the data store is still usable
+	client := NewClient(dataStore)
+	if reply, err := client.Exec("set", []string{"x", "15"}); reply != "OK" || err != nil {
+		t.Errorf("Expected reply: \"OK\", got: \"%s\", %#v", reply, err)
+	}
+}
+
+func TestFinalSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	// obsolete backups are removed
+	for _, name := range []string{"cache_data19990101000000.gob", "cache_data20000101000000.gob"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataStore, err := NewWithOptions(WithBackups(dir, 2), WithWorkers(NoWorkers), WithFinalSnapshot())
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+
+	client := NewClient(dataStore)
+	testData(client, 10)
+	client.Exec("hset", []string{"hash", "key", "value"})
+
+	if err := dataStore.Close(); err != nil {
+		t.Fatalf("Couldn't save final snapshot: %v", err)
+	}
+
+	backups, _ := backupFiles(dir)
+	if len(backups) != 2 || filepath.Base(backups[0]) != "cache_data20000101000000.gob" {
+		t.Fatalf("Expected the latest old backup and the final snapshot, got: %v", backups)
+	}
+
+	restored, _ := NewWithOptions(WithWorkers(NoWorkers))
+	if err := restored.FromFile(backups[1]); err != nil {
+		t.Fatalf("Couldn't restore final snapshot: %v", err)
+	}
+
+	client = NewClient(restored)
+	if reply, _ := client.Exec("size", []string{}); reply != "11" {
+		t.Errorf("Expected reply: \"11\", got: \"%s\"", reply)
+	}
+
+	// nothing is written to the working directory
+	if _, err := os.Stat(backupDir); err == nil {
+		t.Errorf("Expected no %s directory in the working directory", backupDir)
+	}
+}
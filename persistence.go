@@ -1,109 +1,435 @@
 package inmemory
 
 import (
+	"bufio"
 	"encoding/gob"
+	"errors"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+var errSaveRules = errors.New("save rules should be pairs of seconds and changes > 0")
+
+// the backups are named by the time they are saved, the legacy gob encoded
+// backups have their own extension
+const (
+	backupPrefix    = "cache_data"
+	backupExt       = ".snap"
+	legacyBackupExt = ".gob"
+)
+
+func init() {
+	// register the structures for correct encoding for the backup
+	gob.Register(map[string]string{})
+}
+
+// SaveRule makes persistenced save the backup after the number of changes,
+// when the interval passed since the last successful backup.
+type SaveRule struct {
+	Interval time.Duration
+	Changes  int64
+}
+
+// ParseSaveRules parses the pairs of the interval in seconds and the number
+// of changes, e.g. "3600 1 300 100" saves the backup after a single change
+// in an hour or after 100 changes in 5 minutes.
+func ParseSaveRules(rules string) ([]SaveRule, error) {
+	fields := strings.Fields(rules)
+	if len(fields)%2 != 0 {
+		return nil, errSaveRules
+	}
+
+	var parsed []SaveRule
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, errSaveRules
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes <= 0 {
+			return nil, errSaveRules
+		}
+		parsed = append(parsed, SaveRule{Interval: time.Duration(seconds) * time.Second, Changes: changes})
+	}
+	return parsed, nil
+}
+
 // persistenced manages saving inmemory data to disk
 // to be able to restart server and restore all data
 func (dataStore *DataStore) persistenced() {
 
-	// use directory to place backups
-	backupsDir := ".backups"
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-	// create directory if doesn't exist
-	if _, err := os.Stat(backupsDir); os.IsNotExist(err) {
-		os.Mkdir(backupsDir, 0755)
+	for {
+		select {
+		case <-ticker.C:
+		case <-dataStore.done:
+			return
+		}
+
+		if !dataStore.saveNeeded(time.Now()) {
+			continue
+		}
+
+		// log the result of saving the data, the backup started
+		// by BGSAVE is logged by itself
+		switch err := dataStore.backup(); err {
+		case nil:
+			log.Println("Backup created")
+		case errSaving:
+		default:
+			log.Println("Error creating backup", err)
+		}
 	}
+}
 
-	// register the structures for correct encoding for the backup
-	gob.Register(map[string]string{})
+// saveNeeded checks if any of the save rules is satisfied at the moment.
+// The failed backup is retried after saveRetryDelay.
+func (dataStore *DataStore) saveNeeded(now time.Time) bool {
 
-	for {
-		time.Sleep(backupInterval)
+	changes := atomic.LoadInt64(&dataStore.counters.changes)
 
-		// Store all the data in the file
-		err := dataStore.ToFile(backupsDir)
-		// log the result of saving the data
-		if err == nil {
-			log.Println("Backup created")
+	dataStore.counters.Lock()
+	lastBackup, lastBackupErr, lastSave := dataStore.counters.lastBackup, dataStore.counters.lastBackupErr, dataStore.counters.lastSave
+	dataStore.counters.Unlock()
+
+	if lastBackupErr != nil && now.Sub(lastBackup) < saveRetryDelay {
+		return false
+	}
+	// the changes are counted since the start
+	if lastSave.IsZero() {
+		lastSave = dataStore.counters.started
+	}
+
+	for _, rule := range dataStore.config.saveRules {
+		if changes >= rule.Changes && now.Sub(lastSave) >= rule.Interval {
+			return true
+		}
+	}
+	return false
+}
+
+// Save stores all the data in the backup directory and deletes
+// the obsolete backups. It returns when the backup is written.
+func (dataStore *DataStore) Save() error {
+	return dataStore.backup()
+}
+
+// BackgroundSave starts Save in background. The result is logged
+// and reported by INFO, LastSave returns the time it succeeded.
+func (dataStore *DataStore) BackgroundSave() error {
+
+	if !dataStore.startBackup() {
+		return errSaving
+	}
+
+	dataStore.background.Add(1)
+	go func() {
+		defer dataStore.background.Done()
+		if err := dataStore.finishBackup(); err == nil {
+			log.Println("Background backup created")
 		} else {
-			log.Println("Error creating backup", err)
+			log.Println("Error creating background backup", err)
 		}
+	}()
+	return nil
+}
 
-		// number of backups is defined by variable backupNumber
-		backups, err := filepath.Glob(backupsDir + "/cache_data*.gob")
-		if err != nil {
-			log.Println(err)
-		}
-		// delete obsolete backups
-		if len(backups) > backupNumber {
-			// delete all backups except for the defined number
-			for _, old := range backups[0 : len(backups)-backupNumber] {
-				err := os.Remove(old)
-				if err != nil {
-					log.Println(err)
-				}
+// LastSave returns the time of the last successful backup,
+// zero if there was none since the data store was created.
+func (dataStore *DataStore) LastSave() time.Time {
+	dataStore.counters.Lock()
+	defer dataStore.counters.Unlock()
+
+	return dataStore.counters.lastSave
+}
+
+// backup stores all the data in the backup directory
+// and deletes the obsolete backups. The result is reported by INFO.
+// Only one backup runs at a time, errSaving is returned otherwise.
+func (dataStore *DataStore) backup() error {
+
+	if !dataStore.startBackup() {
+		return errSaving
+	}
+	return dataStore.finishBackup()
+}
+
+// startBackup marks the backup as running, unless it's running already.
+func (dataStore *DataStore) startBackup() bool {
+	dataStore.counters.Lock()
+	defer dataStore.counters.Unlock()
+
+	if dataStore.counters.saving {
+		return false
+	}
+	dataStore.counters.saving = true
+	return true
+}
+
+// finishBackup saves the backup started by startBackup.
+func (dataStore *DataStore) finishBackup() error {
+
+	changes := atomic.LoadInt64(&dataStore.counters.changes)
+	started := time.Now()
+
+	err := dataStore.saveBackup()
+
+	finished := time.Now()
+	dataStore.counters.Lock()
+	dataStore.counters.saving = false
+	dataStore.counters.lastBackup = finished
+	dataStore.counters.lastBackupErr = err
+	dataStore.counters.lastBackupDuration = finished.Sub(started)
+	if err == nil {
+		dataStore.counters.lastSave = finished
+	}
+	dataStore.counters.Unlock()
+
+	// the changes made during the backup are left for the next one
+	if err == nil {
+		atomic.AddInt64(&dataStore.counters.changes, -changes)
+	}
+	return err
+}
+
+// saveBackup writes the backup file and deletes the obsolete backups.
+func (dataStore *DataStore) saveBackup() error {
+
+	backupsDir := dataStore.config.backupDir
+
+	// create directory if doesn't exist
+	if err := os.MkdirAll(backupsDir, 0755); err != nil {
+		return err
+	}
+
+	// Store all the data in the file
+	if err := dataStore.ToFile(backupsDir); err != nil {
+		return err
+	}
+
+	// number of backups to keep is defined by the configuration
+	backups, err := backupFiles(backupsDir)
+	if err != nil {
+		log.Println(err)
+	}
+	// delete obsolete backups
+	if len(backups) > dataStore.config.backupNumber {
+		// delete all backups except for the defined number
+		for _, old := range backups[0 : len(backups)-dataStore.config.backupNumber] {
+			err := os.Remove(old)
+			if err != nil {
+				log.Println(err)
 			}
 		}
 	}
+
+	return nil
 }
 
-// ToFile writes all data from the data store.
-// gob encoding is used for the process
-// Only the data is stored, the caching order is omitted
+// backupFiles returns the snapshot and the legacy backups of the directory
+// from the oldest to the newest one.
+func backupFiles(dir string) ([]string, error) {
+	var backups []string
+	for _, ext := range []string{backupExt, legacyBackupExt} {
+		paths, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, paths...)
+	}
+
+	// the names sort by the time of the backup
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// ToFile writes all data from the data store to the new snapshot file in
+// the given directory. The items are saved with their expiration and the
+// caching order, see SnapshotWriter for the format of the file.
+// The file holds the data at the moment ToFile was called. The shards are
+// locked only while the small chunks of the keys are encoded, the changed
+// keys which aren't saved yet are copied by the write commands.
+// The file is written under the temporary name and renamed when it's complete.
 func (dataStore *DataStore) ToFile(path string) error {
 
+	// the shards keep the state of the single snapshot
+	dataStore.snapshotting.Lock()
+	defer dataStore.snapshotting.Unlock()
+
 	timestamp := time.Now().Format("20060102150405")
 
-	path += "/cache_data" + timestamp + ".gob"
+	path = filepath.Join(path, backupPrefix+timestamp+backupExt)
 	// create file to write data to
-	backup, err := os.Create(path)
+	backup, err := os.Create(path + ".tmp")
 
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	defer backup.Close()
+	// the incomplete file is removed
+	defer func() {
+		backup.Close()
+		os.Remove(path + ".tmp")
+	}()
 
-	encCache := gob.NewEncoder(backup)
+	writer := bufio.NewWriter(backup)
+	orders := dataStore.startSnapshot()
+	err = dataStore.saveSnapshot(writer, orders)
+	dataStore.finishSnapshot()
+	if err != nil {
+		return err
+	}
 
-	dataStore.RLock()
-	defer dataStore.RUnlock()
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := backup.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
 
-	return encCache.Encode(dataStore.values)
+// saveSnapshot writes the items of the running snapshot in the given order
+// of the keys of every shard.
+func (dataStore *DataStore) saveSnapshot(w io.Writer, orders [][]string) error {
+
+	writer, err := NewSnapshotWriter(w, time.Now(), dataStore.config.compress, dataStore.config.encryption.key())
+	if err != nil {
+		return err
+	}
+
+	for i, shard := range dataStore.shards {
+		keys := orders[i]
+		for len(keys) > 0 {
+			n := snapshotChunk
+			if n > len(keys) {
+				n = len(keys)
+			}
+
+			// the chunk is encoded under the lock of the shard
+			// and written after it's unlocked
+			if err := shard.saveChunk(writer, keys[:n]); err != nil {
+				return err
+			}
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			keys = keys[n:]
+		}
+	}
+	return writer.Close()
 }
 
-// FromFile reads gob file and restores data store.
-// Also the cache data structure is also filled
+// order returns the keys of the shard from the least to the most recently
+// used one. The keys are in random order, if the policy doesn't keep it.
+func (shard *shard) order() []string {
+	if policy, ok := shard.policy.(OrderedPolicy); ok {
+		return policy.Order()
+	}
+
+	keys := make([]string, 0, len(shard.values))
+	for key := range shard.values {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// FromFile reads the snapshot file and restores the data store. The items
+// are restored with their expiration in the saved caching order, the items
+// which expired meanwhile are skipped. The legacy gob encoded backups are
+// restored as well. The file is read through before the items are restored,
+// so nothing is restored from the corrupted file. The encrypted snapshot
+// is decrypted with the current or the previous keys of WithEncryption.
 func (dataStore *DataStore) FromFile(path string) error {
+	_, err := dataStore.fromFile(path)
+	return err
+}
+
+// fromFile verifies and restores the snapshot file. It reports whether the
+// file was verified, the later errors can leave the data partly restored.
+func (dataStore *DataStore) fromFile(path string) (bool, error) {
 
 	backup, err := os.Open(path)
 
 	if err != nil {
 		log.Println(err)
-		return err
+		return false, err
 	}
 
 	defer backup.Close()
 
-	decCache := gob.NewDecoder(backup)
-	dataStore.Lock()
-	decCache.Decode(&dataStore.values)
+	// the entries are streamed to the shards on the second pass,
+	// so the file isn't held in memory
+	if err := dataStore.readBackup(backup, nil); err != nil {
+		return false, err
+	}
+	if _, err := backup.Seek(0, io.SeekStart); err != nil {
+		return true, err
+	}
+
+	now := time.Now().Unix()
+	restored := 0
+
+	err = dataStore.readBackup(backup, func(entry SnapshotEntry) {
+		if entry.Expire != 0 && entry.Expire < now {
+			return
+		}
+
+		item := &Item{
+			Value:  entry.Value,
+			expire: entry.Expire,
+		}
 
-	// restore cache
-	for _, item := range dataStore.values {
-		el := dataStore.cache.PushFront(item)
-		item.el = el
+		shard := dataStore.shard(entry.Key)
+		shard.Lock()
+		shard.set(entry.Key, item)
+		// the policy resets the usage of the new item
+		if entry.Access != 0 {
+			atomic.StoreInt64(&item.access, entry.Access)
+		}
+		if entry.LFU != 0 {
+			atomic.StoreUint32(&item.lfu, entry.LFU)
+		}
+		shard.Unlock()
+		restored++
+	})
+	if err != nil {
+		return true, err
 	}
-	dataStore.Unlock()
 
-	log.Printf("Restored %d values from backup %s\n", len(dataStore.values), path)
+	log.Printf("Restored %d values from backup %s\n", restored, path)
 
-	return nil
+	return true, nil
+}
+
+// readBackup reads all the entries of the snapshot and passes them to fn,
+// if it's given.
+func (dataStore *DataStore) readBackup(r io.Reader, fn func(entry SnapshotEntry)) error {
+
+	reader, err := NewSnapshotReader(r, dataStore.config.encryption.keys()...)
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fn != nil {
+			fn(entry)
+		}
+	}
 }
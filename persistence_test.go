@@ -0,0 +1,350 @@
+package inmemory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// snapshot saves the data store to the temporary directory and restores
+// it to the new data store with the same policy.
+func snapshot(t *testing.T, dataStore *DataStore) *DataStore {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := dataStore.ToFile(dir); err != nil {
+		t.Fatalf("Couldn't save snapshot: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "cache_data*.snap"))
+	if len(files) != 1 {
+		t.Fatalf("Expected single snapshot, got %v", files)
+	}
+
+	restored, _ := NewWithOptions(WithEvictionPolicy(dataStore.config.policy), WithShards(1), WithWorkers(NoWorkers))
+	if err := restored.FromFile(files[0]); err != nil {
+		t.Fatalf("Couldn't restore snapshot: %v", err)
+	}
+	return restored
+}
+
+func TestSnapshotItems(t *testing.T) {
+	dataStore, _ := NewWithOptions(WithShards(1), WithWorkers(NoWorkers))
+	defer dataStore.Close()
+
+	dataStore.Set("string", "value", NoExpiration)
+	dataStore.Set("expiring", "value", time.Hour)
+	dataStore.LPush("list", "a")
+	dataStore.LPush("list", "b")
+	dataStore.HSet("hash", "a", "1")
+	dataStore.Expire("hash", time.Minute)
+	dataStore.Set("expired", "value", time.Hour)
+	dataStore.shard("expired").values["expired"].expire = time.Now().Unix() - 10
+
+	restored := snapshot(t, dataStore)
+	defer restored.Close()
+
+	if value, _ := restored.Get("string"); value != "value" {
+		t.Errorf("Expected restored string, got %q", value)
+	}
+	if list, _ := restored.List("list"); !reflect.DeepEqual(list, []string{"a", "b"}) {
+		t.Errorf("Expected list [a b], got %v", list)
+	}
+	if hash, _ := restored.Hash("hash"); !reflect.DeepEqual(hash, map[string]string{"a": "1"}) {
+		t.Errorf("Expected restored hash, got %v", hash)
+	}
+	for _, key := range []string{"string", "expiring", "list", "hash"} {
+		expected := dataStore.shard(key).values[key].expire
+		if expire := restored.shard(key).expires[key]; expire != expected {
+			t.Errorf("Expected %s to expire at %d, got %d", key, expected, expire)
+		}
+	}
+	if _, err := restored.Get("expired"); err != ErrNoItem {
+		t.Errorf("Expected expired key to be skipped, got %v", err)
+	}
+	if size := restored.Size(); size != 4 {
+		t.Errorf("Expected 4 keys, got %d", size)
+	}
+}
+
+func TestSnapshotLRUOrder(t *testing.T) {
+	dataStore, _ := NewWithOptions(WithShards(1), WithWorkers(NoWorkers))
+	defer dataStore.Close()
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		dataStore.Set(key, key, NoExpiration)
+	}
+	dataStore.Get("a")
+	dataStore.Get("c")
+
+	restored := snapshot(t, dataStore)
+	defer restored.Close()
+
+	shard := restored.shards[0]
+	if victims := shard.policy.Evict(shard.values, 4); !reflect.DeepEqual(victims, []string{"b", "d", "a", "c"}) {
+		t.Errorf("Expected eviction order [b d a c], got %v", victims)
+	}
+}
+
+func TestSnapshotUsage(t *testing.T) {
+	for _, policy := range []string{"allkeys-approx-lru", "allkeys-lfu"} {
+		dataStore, _ := NewWithOptions(WithEvictionPolicy(policy), WithShards(1), WithWorkers(NoWorkers))
+
+		dataStore.Set("key", "value", NoExpiration)
+		item := dataStore.shards[0].values["key"]
+		item.access = 42
+		item.lfu = lfuTime()<<8 | 100
+
+		restored := snapshot(t, dataStore)
+		if restoredItem := restored.shards[0].values["key"]; restoredItem.access != 42 || restoredItem.lfu != item.lfu {
+			t.Errorf("%s: expected usage %d, %d, got %d, %d", policy, 42, item.lfu, restoredItem.access, restoredItem.lfu)
+		}
+
+		dataStore.Close()
+		restored.Close()
+	}
+}
+
+func TestSnapshotPointInTime(t *testing.T) {
+	dataStore, _ := NewWithOptions(WithShards(2), WithWorkers(NoWorkers))
+	defer dataStore.Close()
+
+	dataStore.Set("string", "before", NoExpiration)
+	dataStore.Set("removed", "before", NoExpiration)
+	dataStore.Set("ttl", "before", NoExpiration)
+	dataStore.LPush("list", "a")
+	dataStore.HSet("hash", "a", "1")
+
+	orders := dataStore.startSnapshot()
+
+	// the changes made after the snapshot started are not saved
+	dataStore.Set("string", "after", NoExpiration)
+	dataStore.Remove("removed")
+	dataStore.Expire("ttl", time.Hour)
+	dataStore.LPush("list", "b")
+	dataStore.HSet("hash", "b", "2")
+	dataStore.Set("created", "after", NoExpiration)
+
+	path := filepath.Join(t.TempDir(), "snapshot.gob")
+	file, _ := os.Create(path)
+	if err := dataStore.saveSnapshot(file, orders); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	dataStore.finishSnapshot()
+
+	restored, _ := NewWithOptions(WithWorkers(NoWorkers))
+	defer restored.Close()
+	if err := restored.FromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"string", "removed", "ttl"} {
+		if value, _ := restored.Get(key); value != "before" {
+			t.Errorf("Expected %s to be saved before the change, got %q", key, value)
+		}
+	}
+	if expire := restored.shard("ttl").values["ttl"].expire; expire != 0 {
+		t.Errorf("Expected no ttl, got %d", expire)
+	}
+	if list, _ := restored.List("list"); !reflect.DeepEqual(list, []string{"a"}) {
+		t.Errorf("Expected list [a], got %v", list)
+	}
+	if hash, _ := restored.Hash("hash"); !reflect.DeepEqual(hash, map[string]string{"a": "1"}) {
+		t.Errorf("Expected hash {a:1}, got %v", hash)
+	}
+	if _, err := restored.Get("created"); err != ErrNoItem {
+		t.Errorf("Expected key created after the snapshot to be skipped, got %v", err)
+	}
+
+	// the items are not preserved after the snapshot
+	dataStore.Set("string", "later", NoExpiration)
+	for _, shard := range dataStore.shards {
+		if shard.snapshot != nil {
+			t.Errorf("Expected snapshot to be finished")
+		}
+	}
+}
+
+func TestSnapshotConcurrentWrites(t *testing.T) {
+	dataStore, _ := NewWithOptions(WithShards(4), WithWorkers(NoWorkers))
+	defer dataStore.Close()
+
+	for i := 0; i < 2*snapshotChunk; i++ {
+		dataStore.LPush("list"+strconv.Itoa(i), "0")
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				key := "list" + strconv.Itoa((i*4+w)%(2*snapshotChunk))
+				dataStore.LPush(key, strconv.Itoa(i))
+				dataStore.Remove("key" + strconv.Itoa(i%10))
+				dataStore.Set("key"+strconv.Itoa(i%10), "value", NoExpiration)
+			}
+		}(w)
+	}
+
+	dir := t.TempDir()
+	err := dataStore.ToFile(dir)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "cache_data*.snap"))
+	restored, _ := NewWithOptions(WithWorkers(NoWorkers))
+	defer restored.Close()
+	if err := restored.FromFile(files[0]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*snapshotChunk; i++ {
+		if list, err := restored.List("list" + strconv.Itoa(i)); err != nil || list[0] != "0" {
+			t.Errorf("Expected list %d to be restored, got %v, %v", i, list, err)
+		}
+	}
+}
+
+func TestParseSaveRules(t *testing.T) {
+	rules, err := ParseSaveRules("3600 1  300 100")
+	expected := []SaveRule{{time.Hour, 1}, {5 * time.Minute, 100}}
+	if err != nil || !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Expected %v, got %v, %v", expected, rules, err)
+	}
+	if rules, err := ParseSaveRules(""); err != nil || len(rules) != 0 {
+		t.Errorf("Expected no rules, got %v, %v", rules, err)
+	}
+
+	for _, wrong := range []string{"3600", "3600 0", "0 1", "hour 1"} {
+		if _, err := ParseSaveRules(wrong); err != errSaveRules {
+			t.Errorf("%q: expected %v, got %v", wrong, errSaveRules, err)
+		}
+	}
+}
+
+func TestSaveNeeded(t *testing.T) {
+	dataStore, _ := NewWithOptions(
+		WithWorkers(NoWorkers),
+		WithBackups(t.TempDir(), 1),
+		WithSaveRules(SaveRule{time.Hour, 1}, SaveRule{time.Minute, 3}),
+	)
+	defer dataStore.Close()
+
+	now := time.Now()
+	if dataStore.saveNeeded(now.Add(2 * time.Hour)) {
+		t.Error("Expected no backup without changes")
+	}
+
+	dataStore.Set("a", "1", NoExpiration)
+	dataStore.Set("b", "2", NoExpiration)
+	if dataStore.saveNeeded(now.Add(2*time.Minute)) || !dataStore.saveNeeded(now.Add(2*time.Hour)) {
+		t.Error("Expected backup of 2 changes after an hour")
+	}
+
+	dataStore.Set("c", "3", NoExpiration)
+	if dataStore.saveNeeded(now) || !dataStore.saveNeeded(now.Add(2*time.Minute)) {
+		t.Error("Expected backup of 3 changes after a minute")
+	}
+
+	if err := dataStore.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if dataStore.saveNeeded(now.Add(2 * time.Hour)) {
+		t.Error("Expected no backup without changes since the last one")
+	}
+
+	// the failed backup is retried after the delay
+	dataStore.Set("d", "4", NoExpiration)
+	dataStore.config.backupDir = filepath.Join(t.TempDir(), "file")
+	os.WriteFile(dataStore.config.backupDir, nil, 0644)
+	if err := dataStore.Save(); err == nil {
+		t.Fatal("Expected backup to fail")
+	}
+	if dataStore.saveNeeded(time.Now().Add(time.Second)) || !dataStore.saveNeeded(time.Now().Add(2*time.Hour)) {
+		t.Error("Expected backup to be retried after the delay")
+	}
+}
+
+func TestSaveCommands(t *testing.T) {
+	dir := t.TempDir()
+	dataStore, _ := NewWithOptions(
+		WithWorkers(NoWorkers),
+		WithBackups(dir, 5),
+		WithAppendOnly(filepath.Join(dir, "data.aof"), FsyncNo),
+	)
+	defer dataStore.Close()
+
+	client := NewClient(dataStore)
+	client.Exec("set", []string{"key", "value"})
+
+	if reply, _ := client.Exec("lastsave", []string{}); reply != "0" {
+		t.Errorf("Expected no save, got %q", reply)
+	}
+	if reply, err := client.Exec("save", []string{}); reply != "OK" || err != nil {
+		t.Fatalf("Expected reply: \"OK\", got: %q, %v", reply, err)
+	}
+	lastSave, _ := client.Exec("lastsave", []string{})
+	if lastSave != strconv.FormatInt(time.Now().Unix(), 10) {
+		t.Errorf("Expected current time, got %q", lastSave)
+	}
+
+	// the running backup isn't started again
+	dataStore.startBackup()
+	if _, err := client.Exec("bgsave", []string{}); err != errSaving {
+		t.Errorf("Expected %v, got %v", errSaving, err)
+	}
+	if info, _ := dataStore.Info("persistence"); infoFields(info)["backup_in_progress"] != "1" {
+		t.Errorf("Expected backup in progress, got: %s", info)
+	}
+	dataStore.finishBackup()
+
+	if reply, err := client.Exec("bgsave", []string{}); reply != "Background saving started" || err != nil {
+		t.Errorf("Expected background save, got: %q, %v", reply, err)
+	}
+	if reply, err := client.Exec("bgrewriteaof", []string{}); err != nil {
+		t.Errorf("Expected background rewrite, got: %q, %v", reply, err)
+	}
+	if _, err := client.Exec("save", []string{"now"}); err != errArgumentNumber {
+		t.Errorf("Expected %v, got %v", errArgumentNumber, err)
+	}
+
+	// both finish before Close returns
+	dataStore.background.Wait()
+
+	fields := infoFields(mustInfo(t, dataStore))
+	if fields["backup_in_progress"] != "0" || fields["last_backup_status"] != "ok" || fields["aof_last_rewrite_status"] != "ok" {
+		t.Errorf("Expected finished backup and rewrite, got: %v", fields)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(dir, "cache_data*.snap")); len(backups) == 0 {
+		t.Error("Expected backups to be saved")
+	}
+
+	noAOF, _ := NewWithOptions(WithWorkers(NoWorkers))
+	defer noAOF.Close()
+	if _, err := NewClient(noAOF).Exec("bgrewriteaof", []string{}); err != errNoAOF {
+		t.Errorf("Expected %v, got %v", errNoAOF, err)
+	}
+}
+
+func mustInfo(t *testing.T, dataStore *DataStore) string {
+	t.Helper()
+
+	info, err := dataStore.Info("persistence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
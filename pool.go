@@ -1,9 +1,12 @@
 package inmemory
 
 import (
+	"io"
 	"log"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 // ConnFactory is a function that creates new connections for the pool.
@@ -18,6 +21,15 @@ type Pool struct {
 	servers sync.Map
 }
 
+// poolServer holds the idle connections to the server and the usage counters.
+type poolServer struct {
+	created uint64
+	closed  uint64
+	gets    uint64
+	failed  uint64
+	conns   chan net.Conn
+}
+
 // NewPool returns pointer to the Pool structure.
 // It has the following parameters:
 // number of connections in the pool for one server address;
@@ -29,8 +41,7 @@ func NewPool(size int, f ConnFactory, servers ...*Server) *Pool {
 
 	// create buffered channel for each server to store the connections
 	for _, server := range servers {
-		ch := make(chan net.Conn, size)
-		pool.servers.Store(server.Addr, ch)
+		pool.servers.Store(server.Addr, &poolServer{conns: make(chan net.Conn, size)})
 	}
 
 	return &pool
@@ -44,22 +55,26 @@ func (p *Pool) Get(addr string) (net.Conn, bool) {
 	if !ok {
 		return nil, false
 	}
-	conns := value.(chan net.Conn)
+	server := value.(*poolServer)
+	atomic.AddUint64(&server.gets, 1)
 
 	select {
-	case conn := <-conns:
+	case conn := <-server.conns:
 		return conn, true
 	default:
 		if p.factory == nil {
 			log.Println("Factory function is not set for pool")
+			atomic.AddUint64(&server.failed, 1)
 			return nil, false
 		}
 		conn, error := p.factory(addr)
 
 		if error != nil {
 			log.Println(error)
+			atomic.AddUint64(&server.failed, 1)
 			return nil, false
 		}
+		atomic.AddUint64(&server.created, 1)
 		return conn, true
 	}
 }
@@ -74,12 +89,52 @@ func (p *Pool) Return(addr string, conn net.Conn) bool {
 		conn.Close()
 		return false
 	}
-	conns := value.(chan net.Conn)
+	server := value.(*poolServer)
 
 	select {
-	case conns <- conn:
+	case server.conns <- conn:
 	default:
 		conn.Close()
+		atomic.AddUint64(&server.closed, 1)
 	}
 	return true
 }
+
+// WriteMetrics writes the usage of the connections to every server
+// in the Prometheus text format.
+func (p *Pool) WriteMetrics(w io.Writer) error {
+	var addrs []string
+	servers := make(map[string]*poolServer)
+	p.servers.Range(func(key, value interface{}) bool {
+		addrs = append(addrs, key.(string))
+		servers[key.(string)] = value.(*poolServer)
+		return true
+	})
+	sort.Strings(addrs)
+
+	m := &metrics{w: w}
+	families := []struct {
+		name, kind, help string
+		value            func(server *poolServer) uint64
+	}{
+		{"inmemory_pool_idle_connections", "gauge", "Number of the idle connections in the pool.",
+			func(server *poolServer) uint64 { return uint64(len(server.conns)) }},
+		{"inmemory_pool_capacity", "gauge", "Max number of the idle connections in the pool.",
+			func(server *poolServer) uint64 { return uint64(cap(server.conns)) }},
+		{"inmemory_pool_connections_created_total", "counter", "Number of the connections created by the pool.",
+			func(server *poolServer) uint64 { return atomic.LoadUint64(&server.created) }},
+		{"inmemory_pool_connections_closed_total", "counter", "Number of the returned connections closed, as the pool was full.",
+			func(server *poolServer) uint64 { return atomic.LoadUint64(&server.closed) }},
+		{"inmemory_pool_gets_total", "counter", "Number of the connections taken from the pool.",
+			func(server *poolServer) uint64 { return atomic.LoadUint64(&server.gets) }},
+		{"inmemory_pool_get_errors_total", "counter", "Number of the failures to create the connection.",
+			func(server *poolServer) uint64 { return atomic.LoadUint64(&server.failed) }},
+	}
+	for _, family := range families {
+		m.family(family.name, family.kind, family.help)
+		for _, addr := range addrs {
+			m.sample(family.name, float64(family.value(servers[addr])), "backend", addr)
+		}
+	}
+	return m.err
+}
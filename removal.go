@@ -0,0 +1,148 @@
+package inmemory
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// RemovalReason tells why the item was removed from the data store.
+type RemovalReason int
+
+// Reasons of the item removal.
+const (
+	// Expired item had exceeded ttl and was removed by ttld.
+	Expired RemovalReason = iota
+	// Evicted item was chosen by the eviction policy to free the memory.
+	Evicted
+	// Removed item was deleted by Remove or RemoveBatch.
+	Removed
+	// Replaced item was overwritten by the new item with the same key.
+	Replaced
+)
+
+var removalReasons = [...]string{
+	Expired:  "expired",
+	Evicted:  "evicted",
+	Removed:  "removed",
+	Replaced: "replaced",
+}
+
+func (reason RemovalReason) String() string {
+	if reason < 0 || int(reason) >= len(removalReasons) {
+		return "unknown"
+	}
+	return removalReasons[reason]
+}
+
+// RemovalListener is called with the key and the value of the removed item.
+type RemovalListener func(key string, value interface{}, reason RemovalReason)
+
+// removal is the removed item waiting to be delivered to the listeners.
+type removal struct {
+	key    string
+	value  interface{}
+	reason RemovalReason
+}
+
+// removals queues the removed items and delivers them to the listeners.
+// The items are queued with the shard locked, so the removals of the same
+// key are delivered in the order they happened. The queue is not bounded,
+// so the slow listeners never block the data store.
+type removals struct {
+	// listening is the number of the listeners, removals aren't queued without them
+	listening int32
+
+	sync.Mutex
+	listeners []RemovalListener
+	queue     []removal
+	closed    bool
+	// ready is signaled when the queue isn't empty
+	ready chan struct{}
+
+	// delivering is held while the removals are passed to the listeners
+	delivering sync.Mutex
+}
+
+func newRemovals() *removals {
+	return &removals{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// listen registers the listener.
+func (removals *removals) listen(listener RemovalListener) {
+	removals.Lock()
+	defer removals.Unlock()
+
+	removals.listeners = append(removals.listeners, listener)
+	atomic.AddInt32(&removals.listening, 1)
+}
+
+// add queues the removed item, if there are listeners.
+func (removals *removals) add(key string, item *Item, reason RemovalReason) {
+	if atomic.LoadInt32(&removals.listening) == 0 {
+		return
+	}
+
+	removals.Lock()
+	defer removals.Unlock()
+
+	if removals.closed {
+		return
+	}
+	removals.queue = append(removals.queue, removal{key: key, value: item.Value, reason: reason})
+
+	select {
+	case removals.ready <- struct{}{}:
+	default:
+	}
+}
+
+// deliver passes the queued removals to the listeners.
+func (removals *removals) deliver() {
+	removals.delivering.Lock()
+	defer removals.delivering.Unlock()
+
+	removals.Lock()
+	queue := removals.queue
+	listeners := removals.listeners
+	removals.queue = nil
+	removals.Unlock()
+
+	for _, removal := range queue {
+		for _, listener := range listeners {
+			listener(removal.key, removal.value, removal.reason)
+		}
+	}
+}
+
+// close stops queueing of the removals.
+func (removals *removals) close() {
+	removals.Lock()
+	defer removals.Unlock()
+
+	removals.closed = true
+}
+
+// OnRemoval registers the listener called for every item removed from the
+// data store: expired, evicted, removed by the commands or replaced by Set.
+// The listeners are called outside of the data store lock by the single
+// goroutine, one removal at a time in the order the removals happened,
+// so the data store is never blocked by the slow listener. The listeners
+// are not called for the removals after Close.
+func (dataStore *DataStore) OnRemoval(listener RemovalListener) {
+	dataStore.removals.listen(listener)
+}
+
+// notifier is the worker delivering the removed items to the listeners.
+func (dataStore *DataStore) notifier() {
+	for {
+		select {
+		case <-dataStore.removals.ready:
+		case <-dataStore.done:
+			return
+		}
+
+		dataStore.removals.deliver()
+	}
+}
@@ -0,0 +1,122 @@
+package inmemory
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// removalRecorder records the removals delivered to the listener.
+type removalRecorder struct {
+	sync.Mutex
+	removals []string
+}
+
+func (recorder *removalRecorder) listen(key string, value interface{}, reason RemovalReason) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	recorder.removals = append(recorder.removals, key+" "+value.(string)+" "+reason.String())
+}
+
+func (recorder *removalRecorder) get() []string {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	return append([]string(nil), recorder.removals...)
+}
+
+func TestOnRemoval(t *testing.T) {
+	dataStore, err := NewWithOptions(WithEvictionPolicy("allkeys-lru"), WithShards(1), WithWorkers(NoWorkers))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+
+	recorder := &removalRecorder{}
+	dataStore.OnRemoval(recorder.listen)
+
+	dataStore.Set("replaced", "old", NoExpiration)
+	dataStore.Set("replaced", "new", NoExpiration)
+	dataStore.Set("removed", "value", NoExpiration)
+	dataStore.Remove("removed")
+	dataStore.Set("expired", "value", time.Second)
+	dataStore.shards[0].removeExpired(time.Now().Unix() + 10)
+	dataStore.shards[0].evict(1)
+
+	// the removals are delivered in order
+	expected := []string{
+		"replaced old replaced",
+		"removed value removed",
+		"expired value expired",
+		"replaced new evicted",
+	}
+	for len(recorder.get()) < len(expected) {
+		time.Sleep(time.Millisecond)
+	}
+	if removals := recorder.get(); !reflect.DeepEqual(removals, expected) {
+		t.Errorf("Expected removals: %v, got: %v", expected, removals)
+	}
+
+	// the listeners are not called after close
+	dataStore.Close()
+	dataStore.Set("key", "value", NoExpiration)
+	dataStore.Remove("key")
+	if removals := recorder.get(); len(removals) != len(expected) {
+		t.Errorf("Expected no removals after close, got: %v", removals)
+	}
+}
+
+func TestOnRemovalSlowListener(t *testing.T) {
+	dataStore := setupTestStore(t)
+
+	release := make(chan struct{})
+	delivered := make(chan string, 100)
+	dataStore.OnRemoval(func(key string, value interface{}, reason RemovalReason) {
+		<-release
+		delivered <- key
+	})
+
+	// the data store isn't blocked by the listener
+	for i := 0; i < 100; i++ {
+		dataStore.Set("key", "value", NoExpiration)
+	}
+	close(release)
+
+	for i := 0; i < 99; i++ {
+		if key := <-delivered; key != "key" {
+			t.Errorf("Expected removal of key, got: %q", key)
+		}
+	}
+}
+
+func TestCacheOnRemoval(t *testing.T) {
+	cache, err := NewBoundedCache[int, string](1, nil)
+	if err != nil {
+		t.Fatalf("Couldn't create cache: %v", err)
+	}
+
+	type removal struct {
+		key    int
+		value  string
+		reason RemovalReason
+	}
+	removals := make(chan removal, 1)
+	cache.OnRemoval(func(key int, value string, reason RemovalReason) {
+		removals <- removal{key, value, reason}
+	})
+
+	cache.Set(1, "a", NoExpiration)
+	cache.Set(2, "b", NoExpiration)
+	cache.Close()
+
+	if r := <-removals; r != (removal{1, "a", Evicted}) {
+		t.Errorf("Expected eviction of the first value, got: %+v", r)
+	}
+}
+
+func TestRemovalReasonString(t *testing.T) {
+	if reason := RemovalReason(10).String(); reason != "unknown" {
+		t.Errorf("Expected unknown reason, got: %q", reason)
+	}
+}
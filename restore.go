@@ -0,0 +1,103 @@
+package inmemory
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+var errNoValidBackup = errors.New("no valid backup to restore")
+
+// loadAppendOnly opens and replays the append-only file. The damaged file
+// fails the creation of the data store, unless WithRestore allows to start
+// without it: then it's moved aside, the records replayed before the damage
+// are dropped and the backups are restored instead.
+func (dataStore *DataStore) loadAppendOnly(factory PolicyFactory) (*appendOnly, error) {
+
+	config := dataStore.config
+	open := func() (*appendOnly, error) {
+		aof, err := openAppendOnly(config.appendOnly, config.appendFsync, config.compress, config.encryption)
+		if err != nil {
+			return nil, err
+		}
+		for _, shard := range dataStore.shards {
+			shard.aof = aof
+		}
+		return aof, nil
+	}
+
+	aof, err := open()
+	if err != nil {
+		return nil, err
+	}
+	err = dataStore.replayAppendOnly(aof)
+	if err == nil {
+		return aof, nil
+	}
+
+	aof.file.Close()
+	if !config.restore || config.restoreRequired {
+		return nil, err
+	}
+
+	log.Printf("Error replaying append-only file %s, moving it to %s: %v\n", config.appendOnly, config.appendOnly+".corrupted", err)
+	if err := os.Rename(config.appendOnly, config.appendOnly+".corrupted"); err != nil {
+		return nil, err
+	}
+	for i := range dataStore.shards {
+		dataStore.shards[i] = newShard(factory(), dataStore.removals)
+	}
+	return open()
+}
+
+// restore restores the data store on creation, see WithRestore.
+func (dataStore *DataStore) restore() error {
+
+	// the append-only file is newer than any backup
+	if dataStore.aof != nil && dataStore.aof.size > 0 {
+		return nil
+	}
+
+	path, err := dataStore.RestoreLatest()
+	if err == errNoValidBackup && !dataStore.config.restoreRequired {
+		log.Println("Starting with empty data store:", err)
+		return nil
+	}
+	if err != nil || path == "" {
+		return err
+	}
+
+	// the restored data isn't logged, the rewrite puts it
+	// to the append-only file, so it's replayed on the next start
+	if dataStore.aof != nil {
+		return dataStore.RewriteAppendOnly()
+	}
+	return nil
+}
+
+// RestoreLatest restores the newest valid backup of the backup directory and
+// returns its path. The backups are checked by reading them through before
+// the data is restored, so the corrupted ones are skipped in favour of the
+// older ones. It returns the empty path if there are no backups.
+func (dataStore *DataStore) RestoreLatest() (string, error) {
+
+	backups, err := backupFiles(dataStore.config.backupDir)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		path := backups[i]
+		verified, err := dataStore.fromFile(path)
+		if !verified {
+			log.Printf("Skipping backup %s: %v\n", path, err)
+			continue
+		}
+		return path, err
+	}
+
+	if len(backups) > 0 {
+		return "", errNoValidBackup
+	}
+	return "", nil
+}
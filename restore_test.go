@@ -0,0 +1,146 @@
+package inmemory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBackup saves the backup holding the key to the directory
+// under the given name.
+func testBackup(t *testing.T, dir, name, key string) string {
+	t.Helper()
+
+	dataStore, _ := NewWithOptions(WithWorkers(NoWorkers))
+	defer dataStore.Close()
+	dataStore.Set(key, "value", NoExpiration)
+
+	temp := t.TempDir()
+	if err := dataStore.ToFile(temp); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := filepath.Glob(filepath.Join(temp, "cache_data*.snap"))
+	path := filepath.Join(dir, name)
+	if err := os.Rename(saved[0], path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// corruptFile flips the byte in the middle of the file.
+func corruptFile(t *testing.T, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2]++
+	os.WriteFile(path, data, 0644)
+}
+
+func TestRestoreLatest(t *testing.T) {
+	dir := t.TempDir()
+	testBackup(t, dir, "cache_data20000101000000.snap", "oldest")
+	testBackup(t, dir, "cache_data20010101000000.snap", "older")
+	corruptFile(t, testBackup(t, dir, "cache_data20020101000000.snap", "newest"))
+
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithBackups(dir, 5), WithRestore(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataStore.Close()
+
+	if _, err := dataStore.Get("older"); err != nil {
+		t.Errorf("Expected the newest valid backup to be restored, got %v", err)
+	}
+	if size := dataStore.Size(); size != 1 {
+		t.Errorf("Expected only one backup to be restored, got %d keys", size)
+	}
+}
+
+func TestRestoreRequired(t *testing.T) {
+	// nothing to restore on the first start
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithBackups(t.TempDir(), 5), WithRestore(true))
+	if err != nil {
+		t.Fatalf("Expected empty data store, got %v", err)
+	}
+	dataStore.Close()
+
+	dir := t.TempDir()
+	corruptFile(t, testBackup(t, dir, "cache_data20000101000000.snap", "key"))
+
+	if _, err := NewWithOptions(WithWorkers(NoWorkers), WithBackups(dir, 5), WithRestore(true)); err != errNoValidBackup {
+		t.Errorf("Expected %v, got %v", errNoValidBackup, err)
+	}
+
+	dataStore, err = NewWithOptions(WithWorkers(NoWorkers), WithBackups(dir, 5), WithRestore(false))
+	if err != nil {
+		t.Fatalf("Expected empty data store, got %v", err)
+	}
+	defer dataStore.Close()
+	if size := dataStore.Size(); size != 0 {
+		t.Errorf("Expected empty data store, got %d keys", size)
+	}
+}
+
+func TestRestoreAppendOnly(t *testing.T) {
+	dir := t.TempDir()
+	aof := filepath.Join(dir, "data.aof")
+	testBackup(t, dir, "cache_data20000101000000.snap", "backup")
+
+	open := func(required bool) (*DataStore, error) {
+		return NewWithOptions(WithWorkers(NoWorkers), WithBackups(dir, 5), WithAppendOnly(aof, FsyncAlways), WithRestore(required))
+	}
+
+	// the backup is restored to the empty append-only file
+	dataStore, err := open(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataStore.Set("logged", "value", NoExpiration)
+	dataStore.Close()
+
+	// the append-only file has the restored data and takes precedence
+	os.Remove(filepath.Join(dir, "cache_data20000101000000.snap"))
+	testBackup(t, dir, "cache_data20010101000000.snap", "ignored")
+
+	dataStore, err = open(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"backup", "logged"} {
+		if _, err := dataStore.Get(key); err != nil {
+			t.Errorf("Expected %q to be replayed, got %v", key, err)
+		}
+	}
+	if _, err := dataStore.Get("ignored"); err != ErrNoItem {
+		t.Errorf("Expected backup to be ignored, got %v", err)
+	}
+	dataStore.Close()
+
+	// the damaged append-only file is moved aside if the data store
+	// can start without it
+	file, _ := os.OpenFile(aof, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("*1\r\n$7\r\nUNKNOWN\r\n")
+	file.Close()
+	if _, err := open(true); err != errAOFFormat {
+		t.Fatalf("Expected %v, got %v", errAOFFormat, err)
+	}
+
+	dataStore, err = open(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataStore.Close()
+
+	if _, err := os.Stat(aof + ".corrupted"); err != nil {
+		t.Errorf("Expected corrupted append-only file to be kept, got %v", err)
+	}
+	if _, err := dataStore.Get("ignored"); err != nil {
+		t.Errorf("Expected backup to be restored, got %v", err)
+	}
+	if size := dataStore.Size(); size != 1 {
+		t.Errorf("Expected only the backup to be restored, got %d keys", size)
+	}
+}
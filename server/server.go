@@ -5,9 +5,15 @@ import (
 	"crypto/tls"
 	"flag"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pasiukevich/inmemory"
 )
@@ -19,7 +25,11 @@ func handleConnection(conn net.Conn, dataStore *inmemory.DataStore) {
 	defer conn.Close()
 
 	// initialize client for the data store
-	client := inmemory.NewClient(dataStore)
+	client := inmemory.NewRemoteClient(dataStore, conn.RemoteAddr().String())
+	defer client.Close()
+
+	// CLIENT KILL from the other connection closes this one
+	client.OnKill(func() { conn.Close() })
 
 	log.Println("Client connected from:", conn.RemoteAddr())
 
@@ -42,6 +52,12 @@ func handleConnection(conn net.Conn, dataStore *inmemory.DataStore) {
 		if len(fields) > 0 {
 			cmd := strings.ToUpper(fields[0])
 
+			// the connection only receives the feed of the commands after MONITOR
+			if cmd == "MONITOR" {
+				monitor(conn, rw, dataStore)
+				return
+			}
+
 			// execute the command with given arguments
 			reply, err := client.Exec(cmd, fields[1:])
 
@@ -59,20 +75,146 @@ func handleConnection(conn net.Conn, dataStore *inmemory.DataStore) {
 	}
 }
 
+// monitor streams the commands executed on the data store to the connection,
+// until it's closed by the client.
+func monitor(conn net.Conn, rw *bufio.ReadWriter, dataStore *inmemory.DataStore) {
+
+	monitor := dataStore.Monitor()
+	defer monitor.Close()
+
+	log.Println("Monitor attached from:", conn.RemoteAddr())
+
+	rw.WriteString("OK\n")
+	rw.Flush()
+
+	// any input is ignored, only the end of the connection is awaited
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, rw)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case line := <-monitor.Feed():
+			rw.WriteString(line + "\n")
+			if err := rw.Flush(); err != nil {
+				return
+			}
+		case <-closed:
+			log.Println("Monitor detached from:", conn.RemoteAddr())
+			return
+		}
+	}
+}
+
 func main() {
 	addrPtr := flag.String("addr", "127.0.0.1:9443", "Address to listen.")
-	backupPtr := flag.String("backup", "", "Path to file with backup in gob format. Used to restore previous state of server.")
+	backupPtr := flag.String("backup", "", "Path to the backup file. Used to restore previous state of server.")
 	certPtr := flag.String("cert", "server.crt", "Server certificate filepath.")
 	keyPtr := flag.String("key", "server.key", "Server key filepath.")
+	evictionPtr := flag.String("eviction", "allkeys-lru", "Eviction policy: "+strings.Join(inmemory.EvictionPolicies(), ", ")+".")
+	shardsPtr := flag.Int("shards", 16, "Number of independently locked shards of the data store.")
+	maxMemoryPtr := flag.Uint64("maxmemory", 5000000, "Max heap memory in bytes, items are evicted above it.")
+	backupsPtr := flag.String("backups", ".backups", "Directory to save backups to.")
+	savePtr := flag.String("save", "3600 1 300 100 60 10000", "Pairs of seconds and number of changes, the backup is saved after the changes in the seconds. Disabled if empty.")
+	backupKeepPtr := flag.Int("backup-keep", 2, "Number of the latest backups to keep.")
+	saveOnExitPtr := flag.Bool("save-on-exit", true, "Save backup when the server is stopped.")
+	metricsPtr := flag.String("metrics", "", "Address to serve Prometheus metrics on /metrics. Disabled if empty.")
+	slowLogThresholdPtr := flag.Duration("slowlog-threshold", 10*time.Millisecond, "Commands running longer are recorded in the slow log.")
+	slowLogLenPtr := flag.Int("slowlog-len", 128, "Number of the latest slow commands to keep.")
+	appendOnlyPtr := flag.String("appendonly", "", "Path to the append-only file logging the write commands. Disabled if empty.")
+	appendFsyncPtr := flag.String("appendfsync", "everysec", "How often the append-only file is synced: always, everysec or no.")
+	aofRewritePercentPtr := flag.Int("aof-rewrite-percentage", 100, "Growth of the append-only file in percents since the last rewrite, which triggers the rewrite. Disabled if 0.")
+	aofRewriteMinSizePtr := flag.Int64("aof-rewrite-min-size", 64<<20, "Min size of the append-only file in bytes to be rewritten.")
+	restorePtr := flag.Bool("restore", true, "Restore the append-only file or the newest valid backup of the backups directory on start, unless -backup is given. Only the append-only file given by -appendonly is replayed, the backups are restored without it.")
+	restoreRequiredPtr := flag.Bool("restore-required", false, "Refuse to start if the data can't be restored.")
+	compressPtr := flag.Bool("compress", false, "Compress the backups and the rewritten append-only file.")
+	encryptionKeyPtr := flag.String("encryption-key", "", "Path to the file with the base64 encoded key encrypting the backups and the append-only file. Taken from "+inmemory.EncryptionKeyEnv+" if empty.")
+	previousKeysPtr := flag.String("previous-encryption-keys", "", "Comma separated paths to the files with the rotated keys, which decrypt the existing data. Taken from "+inmemory.PreviousEncryptionKeysEnv+" if empty.")
 
 	flag.Parse()
 
+	saveRules, err := inmemory.ParseSaveRules(*savePtr)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	options := []inmemory.Option{
+		inmemory.WithEvictionPolicy(*evictionPtr),
+		inmemory.WithShards(*shardsPtr),
+		inmemory.WithMaxMemory(*maxMemoryPtr, 5*time.Second),
+		inmemory.WithBackups(*backupsPtr, *backupKeepPtr),
+		inmemory.WithSaveRules(saveRules...),
+		inmemory.WithSlowLog(*slowLogThresholdPtr, *slowLogLenPtr),
+	}
+	if *saveOnExitPtr {
+		options = append(options, inmemory.WithFinalSnapshot())
+	}
+	if *restorePtr && *backupPtr == "" {
+		options = append(options, inmemory.WithRestore(*restoreRequiredPtr))
+	}
+	if *compressPtr {
+		options = append(options, inmemory.WithCompression())
+	}
+	key, previous, err := inmemory.LoadEncryptionKeys(*encryptionKeyPtr, *previousKeysPtr)
+	if err != nil {
+		log.Println("Error loading encryption key", err)
+		return
+	}
+	if key != nil {
+		options = append(options, inmemory.WithEncryption(key, previous...))
+	}
+	if *appendOnlyPtr != "" {
+		fsync, err := inmemory.ParseFsyncPolicy(*appendFsyncPtr)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		options = append(options,
+			inmemory.WithAppendOnly(*appendOnlyPtr, fsync),
+			inmemory.WithAppendOnlyRewrite(*aofRewritePercentPtr, *aofRewriteMinSizePtr),
+		)
+	}
+
 	// create the data store
-	dataStore := inmemory.New()
+	dataStore, err := inmemory.NewWithOptions(options...)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// stop the workers and save the data on exit
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		log.Println("Shutting down the server")
+		if err := dataStore.Close(); err != nil {
+			log.Println("Error creating backup", err)
+		}
+		os.Exit(0)
+	}()
+
+	// expose the metrics for the monitoring
+	if *metricsPtr != "" {
+		go serveMetrics(*metricsPtr, dataStore)
+	}
 
-	// try to restore data from file if it's given
-	if *backupPtr != "" {
-		dataStore.FromFile(*backupPtr)
+	// try to restore data from file if it's given, the append-only file
+	// replayed on start is newer than any backup
+	switch {
+	case *backupPtr != "" && *appendOnlyPtr != "":
+		log.Println("Backup is not restored, the data is restored from the append-only file")
+	case *backupPtr != "":
+		if err := dataStore.FromFile(*backupPtr); err != nil {
+			log.Println("Error restoring backup", err)
+			if *restoreRequiredPtr {
+				return
+			}
+		}
 	}
 
 	// use the certificates to setup encrypted connections
@@ -102,3 +244,11 @@ func main() {
 		go handleConnection(conn, dataStore)
 	}
 }
+
+func serveMetrics(addr string, writers ...inmemory.MetricsWriter) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", inmemory.MetricsHandler(writers...))
+
+	log.Println("Metrics are served on:", addr)
+	log.Println(http.ListenAndServe(addr, mux))
+}
@@ -0,0 +1,271 @@
+package inmemory
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// shard is the independently locked part of the data store.
+// Each key belongs to exactly one shard, selected by the key hash,
+// so the commands working with different shards don't block each other.
+// Every shard has its own eviction policy and expiration tracking.
+type shard struct {
+	// statistics counters are first to be 64-bit aligned for atomic operations
+	hits    uint64
+	misses  uint64
+	expired uint64
+	evicted uint64
+	// used is the total size of the items, counted only for the bounded Cache
+	used int64
+
+	sync.RWMutex
+	values map[string]*Item
+	policy EvictionPolicy
+	// expires holds absolute expiration time of the items with ttl
+	expires map[string]int64
+	// concurrent is set if the policy allows the reads under the shared lock
+	concurrent bool
+	// removals receives the removed items for the removal listeners
+	removals *removals
+	// aof logs the removals made by the workers, nil if it's disabled
+	aof *appendOnly
+	// snapshot is the state of the running snapshot, nil if there is none
+	snapshot *shardSnapshot
+}
+
+func newShard(policy EvictionPolicy, removals *removals) *shard {
+	concurrent, ok := policy.(ConcurrentPolicy)
+
+	return &shard{
+		values:     make(map[string]*Item),
+		policy:     policy,
+		expires:    make(map[string]int64),
+		concurrent: ok && concurrent.ConcurrentAccess(),
+		removals:   removals,
+	}
+}
+
+// lockAccess locks the shard for the read command. The shared lock is enough
+// if the eviction policy tracks the accesses concurrently.
+func (shard *shard) lockAccess() {
+	if shard.concurrent {
+		shard.RLock()
+	} else {
+		shard.Lock()
+	}
+}
+
+// unlockAccess unlocks the shard locked by lockAccess.
+func (shard *shard) unlockAccess() {
+	if shard.concurrent {
+		shard.RUnlock()
+	} else {
+		shard.Unlock()
+	}
+}
+
+// shard returns the shard the key belongs to.
+// FNV-1a hash is calculated inline to avoid allocations on every command.
+func (dataStore *DataStore) shard(key string) *shard {
+	if len(dataStore.shards) == 1 {
+		return dataStore.shards[0]
+	}
+
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return dataStore.shards[hash%uint32(len(dataStore.shards))]
+}
+
+// rlockAll locks all the shards for reading, always in the same order,
+// so the commands working with the whole data store see its consistent state.
+func (dataStore *DataStore) rlockAll() {
+	for _, shard := range dataStore.shards {
+		shard.RLock()
+	}
+}
+
+func (dataStore *DataStore) runlockAll() {
+	for _, shard := range dataStore.shards {
+		shard.RUnlock()
+	}
+}
+
+// get fetches Item pointer from the shard.
+func (shard *shard) get(key string) (*Item, bool) {
+	value, ok := shard.values[key]
+	if ok {
+		return value, true
+	}
+	return nil, false
+}
+
+// lookup fetches Item pointer from the shard for the read commands.
+// The result of the lookup is counted in the data store statistics.
+func (shard *shard) lookup(key string) (*Item, bool) {
+	item, ok := shard.get(key)
+	if ok {
+		atomic.AddUint64(&shard.hits, 1)
+	} else {
+		atomic.AddUint64(&shard.misses, 1)
+	}
+	return item, ok
+}
+
+// set stores Item pointer in the shard and registers it in the cache.
+// Previous item with the same key is replaced.
+func (shard *shard) set(key string, value *Item) {
+	shard.preserve(key)
+	if old, ok := shard.values[key]; ok {
+		shard.policy.Remove(key, old)
+		atomic.AddInt64(&shard.used, -old.size)
+		shard.removals.add(key, old, Replaced)
+	}
+	shard.values[key] = value
+	atomic.AddInt64(&shard.used, value.size)
+	shard.policy.Add(key, value)
+	shard.expire(key, value, value.expire)
+}
+
+// touch updates the item as the most recently used in cache.
+func (shard *shard) touch(key string, item *Item) {
+	shard.policy.Access(key, item)
+}
+
+// expire sets absolute expiration time of the item, zero removes the expiration.
+func (shard *shard) expire(key string, item *Item, expire int64) {
+	item.expire = expire
+	if expire == 0 {
+		delete(shard.expires, key)
+	} else {
+		shard.expires[key] = expire
+	}
+}
+
+// remove item from the shard by the given key.
+// the item is also removed from cache
+// The removal listeners are notified with the reason.
+func (shard *shard) remove(key string, reason RemovalReason) error {
+	item, ok := shard.get(key)
+
+	if !ok {
+		return ErrNoItem
+	}
+
+	shard.preserve(key)
+	shard.policy.Remove(key, item)
+	delete(shard.values, key)
+	delete(shard.expires, key)
+	atomic.AddInt64(&shard.used, -item.size)
+	shard.removals.add(key, item, reason)
+
+	switch reason {
+	case Expired:
+		atomic.AddUint64(&shard.expired, 1)
+	case Evicted:
+		atomic.AddUint64(&shard.evicted, 1)
+	}
+
+	// the write commands log their removals themselves
+	if reason == Expired || reason == Evicted {
+		if err := shard.aof.append(shard, "REMOVE", key); err != nil {
+			log.Println("Error writing to append-only file", err)
+		}
+	}
+	return nil
+}
+
+// removeExpired removes the items of the shard with exceeded ttl.
+func (shard *shard) removeExpired(now int64) {
+	shard.Lock()
+	defer shard.Unlock()
+
+	for key, expire := range shard.expires {
+		if expire < now {
+			shard.remove(key, Expired)
+		}
+	}
+}
+
+// evict removes up to n items chosen by the eviction policy.
+// It returns number of the removed items.
+func (shard *shard) evict(n int) int {
+	shard.Lock()
+	defer shard.Unlock()
+
+	return shard.evictLocked(n)
+}
+
+// evictLocked is evict for the shard locked by the caller.
+func (shard *shard) evictLocked(n int) int {
+	keys := shard.policy.Evict(shard.values, n)
+	for _, key := range keys {
+		shard.remove(key, Evicted)
+	}
+	return len(keys)
+}
+
+// used returns the total size of the items in the data store.
+func (dataStore *DataStore) used() int64 {
+	var used int64
+	for _, shard := range dataStore.shards {
+		used += atomic.LoadInt64(&shard.used)
+	}
+	return used
+}
+
+// reserve evicts the items until the item fits in the limit. The size is
+// called on every check, as the eviction may remove the item it replaces.
+// The items are evicted from the fullest shards first. The given shard is
+// locked by the caller. The shards locked by the others are waited for only
+// if the rest has nothing to evict, with the given shard unlocked meanwhile,
+// as their holders may wait for it. The limit isn't shared by the shards,
+// so the concurrent reservations in the different shards can exceed it by
+// the sizes of their items, until the next reservations evict them.
+// It reports whether the room was made.
+func (dataStore *DataStore) reserve(size func() int64, limit int64, locked *shard) bool {
+	shards := make([]*shard, len(dataStore.shards))
+	for dataStore.used()+size() > limit {
+		copy(shards, dataStore.shards)
+		sort.Slice(shards, func(i, j int) bool {
+			return atomic.LoadInt64(&shards[i].used) > atomic.LoadInt64(&shards[j].used)
+		})
+
+		evicted := 0
+		var busy []*shard
+		for _, shard := range shards {
+			switch {
+			case shard == locked:
+				evicted = shard.evictLocked(1)
+			case shard.TryLock():
+				evicted = shard.evictLocked(1)
+				shard.Unlock()
+			default:
+				busy = append(busy, shard)
+			}
+			if evicted > 0 {
+				break
+			}
+		}
+
+		if evicted == 0 && len(busy) > 0 {
+			locked.Unlock()
+			for _, shard := range busy {
+				if evicted = shard.evict(1); evicted > 0 {
+					break
+				}
+			}
+			locked.Lock()
+		}
+
+		// the policies refuse to evict anything
+		if evicted == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package inmemory
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSharded(t *testing.T) {
+	dataStore, err := NewSharded(defaultPolicy, 4)
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	if len(dataStore.shards) != 4 {
+		t.Errorf("Expected 4 shards, got: %d", len(dataStore.shards))
+	}
+
+	if _, err := NewSharded(defaultPolicy, 0); err != errShardsNumber {
+		t.Errorf("Expected error: %#v, got: %#v", errShardsNumber, err)
+	}
+	if _, err := NewSharded("wrong-policy", 4); err != errNoSuchPolicy {
+		t.Errorf("Expected error: %#v, got: %#v", errNoSuchPolicy, err)
+	}
+}
+
+func TestShardsDistribution(t *testing.T) {
+	client := setupTestClient()
+
+	testData(client, 1000)
+
+	// every shard gets some keys, and the same key always goes to the same shard
+	for i, shard := range client.ds.shards {
+		if len(shard.values) == 0 {
+			t.Errorf("Expected keys in shard %d", i)
+		}
+		for key := range shard.values {
+			if client.ds.shard(key) != shard {
+				t.Errorf("Key %s is stored in the wrong shard %d", key, i)
+			}
+		}
+	}
+}
+
+func TestCrossShardCommands(t *testing.T) {
+	client := setupTestClient()
+
+	testData(client, 100)
+
+	if reply, _ := client.Exec("size", []string{}); reply != "100" {
+		t.Errorf("Expected reply: \"100\", got: \"%s\"", reply)
+	}
+
+	reply, _ := client.Exec("keys", []string{})
+	keys := strings.Fields(reply)
+	sort.Strings(keys)
+	if len(keys) != 100 || keys[0] != "key0" || keys[99] != "key99" {
+		t.Errorf("Expected 100 keys from key0 to key99, got: %v", keys)
+	}
+
+	var batch []string
+	for i := 0; i < 50; i++ {
+		batch = append(batch, "key"+strconv.Itoa(i))
+	}
+	client.Exec("remove_batch", batch)
+
+	if reply, _ := client.Exec("size", []string{}); reply != "50" {
+		t.Errorf("Expected reply: \"50\", got: \"%s\"", reply)
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	client := setupTestClient()
+
+	client.Exec("set", []string{"volatile", "value", "10"})
+	client.Exec("set", []string{"persistent", "value", "0"})
+	client.Exec("lpush", []string{"list", "value"})
+	client.Exec("ttl", []string{"list", "20"})
+	client.Exec("ttl", []string{"list", "0"})
+
+	// emulate ttld running after the expiration
+	later := time.Now().Unix() + 60
+	for _, shard := range client.ds.shards {
+		shard.removeExpired(later)
+	}
+
+	if _, err := client.Exec("get", []string{"volatile"}); err != ErrNoItem {
+		t.Errorf("Expected error: %#v, got: %#v", ErrNoItem, err)
+	}
+	if reply, _ := client.Exec("size", []string{}); reply != "2" {
+		t.Errorf("Expected reply: \"2\", got: \"%s\"", reply)
+	}
+}
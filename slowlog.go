@@ -0,0 +1,148 @@
+package inmemory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// limits of the arguments kept in the slow log entry
+const (
+	slowLogMaxArgs   = 32
+	slowLogMaxArgLen = 128
+)
+
+// SlowLogEntry is the command which ran longer than the slow log threshold.
+// The number of the arguments and their length are truncated.
+type SlowLogEntry struct {
+	ID         int64
+	Time       time.Time
+	Duration   time.Duration
+	Command    string
+	Args       []string
+	ClientAddr string
+}
+
+// String formats the entry as "id unix_time duration_us client command args".
+func (entry SlowLogEntry) String() string {
+	result := fmt.Sprintf("%d %d %d %s %s", entry.ID, entry.Time.Unix(),
+		entry.Duration/time.Microsecond, entry.ClientAddr, entry.Command)
+	for _, arg := range entry.Args {
+		result += " " + arg
+	}
+	return result
+}
+
+// slowLog keeps the latest slow commands in the ring buffer.
+type slowLog struct {
+	sync.Mutex
+	threshold time.Duration
+	entries   []SlowLogEntry
+	// next is the position of the next entry in the ring
+	next   int
+	lastID int64
+}
+
+func newSlowLog(threshold time.Duration, size int) *slowLog {
+	return &slowLog{
+		threshold: threshold,
+		entries:   make([]SlowLogEntry, 0, size),
+	}
+}
+
+// add records the command, if it ran longer than the threshold.
+func (slowlog *slowLog) add(client *Client, duration time.Duration) {
+	if duration < slowlog.threshold || cap(slowlog.entries) == 0 {
+		return
+	}
+
+	entry := SlowLogEntry{
+		Time:       time.Now(),
+		Duration:   duration,
+		Command:    client.cmd,
+		Args:       truncateArgs(client.args),
+		ClientAddr: client.addr,
+	}
+
+	slowlog.Lock()
+	defer slowlog.Unlock()
+
+	slowlog.lastID++
+	entry.ID = slowlog.lastID
+
+	if len(slowlog.entries) < cap(slowlog.entries) {
+		slowlog.entries = append(slowlog.entries, entry)
+	} else {
+		slowlog.entries[slowlog.next] = entry
+	}
+	slowlog.next = (slowlog.next + 1) % cap(slowlog.entries)
+}
+
+// truncateArgs copies the arguments, the extra ones are replaced with
+// the number of them and the long ones are cut.
+func truncateArgs(args []string) []string {
+	n := len(args)
+	if n > slowLogMaxArgs {
+		n = slowLogMaxArgs - 1
+	}
+
+	result := make([]string, 0, n+1)
+	for _, arg := range args[:n] {
+		if len(arg) > slowLogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowLogMaxArgLen], len(arg)-slowLogMaxArgLen)
+		}
+		result = append(result, arg)
+	}
+	if n < len(args) {
+		result = append(result, fmt.Sprintf("... (%d more arguments)", len(args)-n))
+	}
+	return result
+}
+
+// get returns up to n latest entries, the newest first.
+func (slowlog *slowLog) get(n int) []SlowLogEntry {
+	slowlog.Lock()
+	defer slowlog.Unlock()
+
+	if n > len(slowlog.entries) || n < 0 {
+		n = len(slowlog.entries)
+	}
+
+	result := make([]SlowLogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		index := (slowlog.next - i + cap(slowlog.entries)) % cap(slowlog.entries)
+		result = append(result, slowlog.entries[index])
+	}
+	return result
+}
+
+func (slowlog *slowLog) len() int {
+	slowlog.Lock()
+	defer slowlog.Unlock()
+
+	return len(slowlog.entries)
+}
+
+func (slowlog *slowLog) reset() {
+	slowlog.Lock()
+	defer slowlog.Unlock()
+
+	slowlog.entries = slowlog.entries[:0]
+	slowlog.next = 0
+}
+
+// SlowLog returns up to n latest commands which ran longer than the slow
+// log threshold, the newest first. Negative n returns all of them.
+func (dataStore *DataStore) SlowLog(n int) []SlowLogEntry {
+	return dataStore.slowLog.get(n)
+}
+
+// SlowLogLen returns the number of the entries in the slow log.
+func (dataStore *DataStore) SlowLogLen() int {
+	return dataStore.slowLog.len()
+}
+
+// ResetSlowLog removes all the entries from the slow log.
+func (dataStore *DataStore) ResetSlowLog() {
+	dataStore.slowLog.reset()
+}
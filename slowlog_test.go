@@ -0,0 +1,131 @@
+package inmemory
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithSlowLog(0, 3))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	client := NewRemoteClient(dataStore, "127.0.0.1:5000")
+	defer client.Close()
+
+	for i := 0; i < 5; i++ {
+		client.Exec("SET", []string{"key" + strconv.Itoa(i), "value"})
+	}
+
+	// only the latest entries are kept, the newest first
+	entries := dataStore.SlowLog(-1)
+	if len(entries) != 3 || dataStore.SlowLogLen() != 3 {
+		t.Fatalf("Expected 3 entries, got: %v", entries)
+	}
+	for i, entry := range entries {
+		expected := SlowLogEntry{
+			ID:         int64(5 - i),
+			Command:    "SET",
+			Args:       []string{"key" + strconv.Itoa(4-i), "value"},
+			ClientAddr: "127.0.0.1:5000",
+		}
+		entry.Time, entry.Duration = time.Time{}, 0
+		if !reflect.DeepEqual(entry, expected) {
+			t.Errorf("Expected entry %+v, got: %+v", expected, entry)
+		}
+	}
+	if entries := dataStore.SlowLog(1); len(entries) != 1 || entries[0].ID != 5 {
+		t.Errorf("Expected the latest entry, got: %v", entries)
+	}
+
+	dataStore.ResetSlowLog()
+	if entries := dataStore.SlowLog(10); len(entries) != 0 {
+		t.Errorf("Expected no entries after reset, got: %v", entries)
+	}
+}
+
+func TestSlowLogThreshold(t *testing.T) {
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithSlowLog(time.Hour, 10))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	NewClient(dataStore).Exec("SET", []string{"key", "value"})
+	if n := dataStore.SlowLogLen(); n != 0 {
+		t.Errorf("Expected no fast commands in the slow log, got: %d", n)
+	}
+}
+
+func TestTruncateArgs(t *testing.T) {
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = "arg"
+	}
+	args[0] = strings.Repeat("a", 130)
+
+	truncated := truncateArgs(args)
+	if len(truncated) != slowLogMaxArgs {
+		t.Errorf("Expected %d arguments, got: %d", slowLogMaxArgs, len(truncated))
+	}
+	if expected := strings.Repeat("a", 128) + "... (2 more bytes)"; truncated[0] != expected {
+		t.Errorf("Expected %q, got: %q", expected, truncated[0])
+	}
+	if last := truncated[len(truncated)-1]; last != "... (9 more arguments)" {
+		t.Errorf("Expected number of the omitted arguments, got: %q", last)
+	}
+}
+
+func TestSlowLogCommand(t *testing.T) {
+	dataStore, err := NewWithOptions(WithWorkers(NoWorkers), WithSlowLog(0, 10))
+	if err != nil {
+		t.Fatalf("Couldn't create data store: %v", err)
+	}
+	defer dataStore.Close()
+
+	client := NewRemoteClient(dataStore, "127.0.0.1:5000")
+	client.Exec("GET", []string{"key"})
+	client.Exec("SIZE", nil)
+
+	reply, err := client.Exec("SLOWLOG", []string{"get", "1"})
+	if err != nil {
+		t.Fatalf("SLOWLOG GET failed: %v", err)
+	}
+	fields := strings.Fields(reply)
+	if len(fields) != 5 || fields[0] != "2" || fields[3] != "127.0.0.1:5000" || fields[4] != "SIZE" {
+		t.Errorf("Expected the latest SIZE entry, got: %q", reply)
+	}
+
+	if reply, _ := client.Exec("SLOWLOG", []string{"GET"}); len(strings.Split(reply, "; ")) != 3 {
+		t.Errorf("Expected 3 entries, got: %q", reply)
+	}
+	if reply, _ := client.Exec("SLOWLOG", []string{"LEN"}); reply != "4" {
+		t.Errorf("Expected 4 entries, got: %q", reply)
+	}
+	if reply, _ := client.Exec("SLOWLOG", []string{"RESET"}); reply != "OK" {
+		t.Errorf("Expected OK, got: %q", reply)
+	}
+
+	errorCases := []struct {
+		name          string
+		args          []string
+		expectedError error
+	}{
+		{"no subcommand", nil, errArgumentNumber},
+		{"wrong count", []string{"GET", "a"}, errCountFormat},
+		{"len with argument", []string{"LEN", "1"}, errArgumentNumber},
+		{"unknown subcommand", []string{"WRONG"}, errNoSubcommand},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := client.Exec("SLOWLOG", tc.args); err != tc.expectedError {
+				t.Errorf("Expected %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package inmemory
+
+import (
+	"sync/atomic"
+)
+
+// number of the keys encoded at once with the shard locked
+const snapshotChunk = 512
+
+// shardSnapshot is the state of the running snapshot of the shard.
+// It's changed with the shard locked for writing, both by the write
+// commands and by the snapshot.
+type shardSnapshot struct {
+	// pending are the keys existing when the snapshot started, which are
+	// neither saved nor changed yet
+	pending map[string]struct{}
+	// preserved are the copies of the pending items made before they were
+	// changed. The copy is saved instead of the current item.
+	preserved map[string]SnapshotEntry
+}
+
+// startSnapshot captures the keys of all the shards at once in the caching
+// order and makes the write commands preserve the items before changing them.
+// The extra memory of the snapshot grows with the number of the keys: every
+// key is held in the order and in the pending set until it's saved, the key
+// strings themselves are shared with the shard. The values are copied only
+// when they are changed before they are saved.
+func (dataStore *DataStore) startSnapshot() [][]string {
+	dataStore.rlockAll()
+	defer dataStore.runlockAll()
+
+	orders := make([][]string, len(dataStore.shards))
+	for i, shard := range dataStore.shards {
+		orders[i] = shard.order()
+
+		pending := make(map[string]struct{}, len(orders[i]))
+		for _, key := range orders[i] {
+			pending[key] = struct{}{}
+		}
+		shard.snapshot = &shardSnapshot{
+			pending:   pending,
+			preserved: make(map[string]SnapshotEntry),
+		}
+	}
+	return orders
+}
+
+// finishSnapshot stops preserving the changed items.
+func (dataStore *DataStore) finishSnapshot() {
+	for _, shard := range dataStore.shards {
+		shard.Lock()
+		shard.snapshot = nil
+		shard.Unlock()
+	}
+}
+
+// preserve copies the item by the key, if it's not saved by the running
+// snapshot yet. It's called with the shard locked before the item is
+// changed, replaced or removed.
+func (shard *shard) preserve(key string) {
+	snapshot := shard.snapshot
+	if snapshot == nil {
+		return
+	}
+	if _, ok := snapshot.pending[key]; !ok {
+		return
+	}
+
+	delete(snapshot.pending, key)
+	entry := newSnapshotEntry(key, shard.values[key])
+	entry.Value = copyValue(entry.Value)
+	snapshot.preserved[key] = entry
+}
+
+// saveChunk encodes the items by the keys as they were when the snapshot
+// started. The keys created later are skipped.
+func (shard *shard) saveChunk(writer *SnapshotWriter, keys []string) error {
+	shard.Lock()
+	defer shard.Unlock()
+
+	snapshot := shard.snapshot
+	for _, key := range keys {
+		entry, ok := snapshot.preserved[key]
+		if ok {
+			delete(snapshot.preserved, key)
+		} else if _, ok = snapshot.pending[key]; ok {
+			delete(snapshot.pending, key)
+			entry = newSnapshotEntry(key, shard.values[key])
+		}
+
+		if !ok {
+			continue
+		}
+		if err := writer.Write(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newSnapshotEntry(key string, item *Item) SnapshotEntry {
+	return SnapshotEntry{
+		Key:    key,
+		Value:  item.Value,
+		Expire: item.expire,
+		Access: atomic.LoadInt64(&item.access),
+		LFU:    atomic.LoadUint32(&item.lfu),
+	}
+}
+
+// copyValue returns the copy of the value, which isn't changed by the write
+// commands changing the lists and hashes in place.
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case []string:
+		return append([]string(nil), value...)
+	case map[string]string:
+		copied := make(map[string]string, len(value))
+		for k, v := range value {
+			copied[k] = v
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,530 @@
+package inmemory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// The snapshot file starts with the header, followed by the sections of
+// the entries and the end section. All integers are little endian.
+//
+// Header, 24 bytes:
+//
+//	magic    [8]byte  "INMEMSNP"
+//	version  uint16   1
+//	flags    uint16   1 if the payloads are compressed with gzip,
+//	                  2 if they are encrypted with AES-GCM
+//	created  int64    unix time in seconds
+//	crc      uint32   CRC-32C of the header fields
+//
+// Section:
+//
+//	kind     uint8    1 for the entries, 2 for the end
+//	count    uint32   number of the entries in the section, or in the whole
+//	                  file for the end section
+//	length   uint32   length of the payload, 0 for the end section
+//	payload  [length]byte
+//	crc      uint32   CRC-32C of the section fields and the payload
+//
+// The compressed payload is gzip stream of the entries. The encrypted payload
+// is the 8 bytes id of the key, 12 bytes nonce and the sealed entries, which
+// are compressed first if both flags are set. The kind and count of the
+// section are authenticated with the payload.
+//
+// Entry in the payload, the numbers are varints and the strings are
+// prefixed with their uvarint length:
+//
+//	key      string
+//	type     uint8    's' string, 'l' list, 'h' hash
+//	expire   varint   absolute expiration time in seconds, 0 without ttl
+//	access   varint   last access time of the approximated LRU
+//	lfu      uvarint  access counter of the LFU policies
+//	value    string, or uvarint number of the list values followed by them,
+//	         or uvarint number of the hash fields followed by the key and
+//	         value of every field
+//
+// The file ends right after the end section.
+const (
+	snapshotMagic   = "INMEMSNP"
+	snapshotVersion = 1
+
+	snapshotHeaderSize  = 24
+	sectionHeaderSize   = 9
+	sectionEntries      = 1
+	sectionEnd          = 2
+	maxSnapshotSection  = 1 << 30
+	snapshotSectionSize = 1 << 20
+)
+
+var (
+	errSnapshotCorrupted = errors.New("snapshot file is corrupted")
+	errSnapshotChecksum  = errors.New("snapshot file checksum mismatch")
+	errSnapshotVersion   = errors.New("unsupported snapshot file version")
+	errSnapshotTruncated = errors.New("snapshot file is truncated")
+	errSnapshotValue     = errors.New("value type can't be saved in snapshot")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// SnapshotEntry is the item saved in the snapshot. The entries of every
+// shard are saved from the least to the most recently used one, if the
+// eviction policy keeps the order, so the restored keys are evicted in
+// the same order.
+type SnapshotEntry struct {
+	Key string
+	// Value is string, []string or map[string]string
+	Value interface{}
+	// Expire is the absolute expiration time in seconds, zero without ttl
+	Expire int64
+	// Access is the last access time of the approximated LRU in nanoseconds
+	Access int64
+	// LFU is the access counter of the LFU policies
+	LFU uint32
+}
+
+// SnapshotWriter writes the entries in the snapshot file format.
+// The entries are buffered and written by sections.
+type SnapshotWriter struct {
+	w     io.Writer
+	flags uint16
+	key   *EncryptionKey
+	buf   []byte
+	count uint32
+	total uint32
+	// scratch holds the encoded varint
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// NewSnapshotWriter writes the header of the snapshot file to w.
+// The entries are compressed if compress is set and encrypted
+// if the key is given. The snapshot has to be finished with Close.
+func NewSnapshotWriter(w io.Writer, created time.Time, compress bool, key *EncryptionKey) (*SnapshotWriter, error) {
+	var flags uint16
+	if compress {
+		flags |= flagCompressed
+	}
+	if key != nil {
+		flags |= flagEncrypted
+	}
+
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, snapshotMagic)
+	binary.LittleEndian.PutUint16(header[8:], snapshotVersion)
+	binary.LittleEndian.PutUint16(header[10:], flags)
+	binary.LittleEndian.PutUint64(header[12:], uint64(created.Unix()))
+	binary.LittleEndian.PutUint32(header[20:], crc32.Checksum(header[:20], castagnoli))
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &SnapshotWriter{w: w, flags: flags, key: key}, nil
+}
+
+// Write adds the entry to the current section. The full section is written
+// before the entry is added, the rest of them are written by Flush.
+func (sw *SnapshotWriter) Write(entry SnapshotEntry) error {
+	if len(sw.buf) >= snapshotSectionSize {
+		if err := sw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	buf := sw.appendString(sw.buf, entry.Key)
+	switch value := entry.Value.(type) {
+	case string:
+		buf = append(buf, 's')
+		buf = sw.appendUsage(buf, entry)
+		buf = sw.appendString(buf, value)
+	case []string:
+		buf = append(buf, 'l')
+		buf = sw.appendUsage(buf, entry)
+		buf = sw.appendUvarint(buf, uint64(len(value)))
+		for _, v := range value {
+			buf = sw.appendString(buf, v)
+		}
+	case map[string]string:
+		buf = append(buf, 'h')
+		buf = sw.appendUsage(buf, entry)
+		buf = sw.appendUvarint(buf, uint64(len(value)))
+		for k, v := range value {
+			buf = sw.appendString(buf, k)
+			buf = sw.appendString(buf, v)
+		}
+	default:
+		return errSnapshotValue
+	}
+
+	sw.buf = buf
+	sw.count++
+	return nil
+}
+
+func (sw *SnapshotWriter) appendUsage(buf []byte, entry SnapshotEntry) []byte {
+	n := binary.PutVarint(sw.scratch[:], entry.Expire)
+	buf = append(buf, sw.scratch[:n]...)
+	n = binary.PutVarint(sw.scratch[:], entry.Access)
+	buf = append(buf, sw.scratch[:n]...)
+	return sw.appendUvarint(buf, uint64(entry.LFU))
+}
+
+func (sw *SnapshotWriter) appendUvarint(buf []byte, value uint64) []byte {
+	n := binary.PutUvarint(sw.scratch[:], value)
+	return append(buf, sw.scratch[:n]...)
+}
+
+func (sw *SnapshotWriter) appendString(buf []byte, value string) []byte {
+	buf = sw.appendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// Flush writes the buffered entries as the section.
+func (sw *SnapshotWriter) Flush() error {
+	if sw.count == 0 {
+		return nil
+	}
+
+	err := sw.writeSection(sectionEntries, sw.count, sw.buf)
+	sw.total += sw.count
+	sw.count = 0
+	sw.buf = sw.buf[:0]
+	return err
+}
+
+// Close writes the buffered entries and the end section.
+// The underlying writer is not closed.
+func (sw *SnapshotWriter) Close() error {
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	return sw.writeSection(sectionEnd, sw.total, nil)
+}
+
+func (sw *SnapshotWriter) writeSection(kind byte, count uint32, payload []byte) error {
+	header := make([]byte, sectionHeaderSize)
+	header[0] = kind
+	binary.LittleEndian.PutUint32(header[1:], count)
+
+	if kind == sectionEntries && sw.flags != 0 {
+		var err error
+		if payload, err = encodePayload(payload, sw.flags, sw.key, header[:5]); err != nil {
+			return err
+		}
+	}
+	binary.LittleEndian.PutUint32(header[5:], uint32(len(payload)))
+
+	crc := crc32.Update(crc32.Checksum(header, castagnoli), castagnoli, payload)
+	trailer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(trailer, crc)
+
+	for _, part := range [][]byte{header, payload, trailer} {
+		if _, err := sw.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SnapshotReader reads the entries of the snapshot file one by one.
+// The checksum of every section is verified before its entries are returned.
+// The legacy backups, which are gob encoded, are read as well.
+type SnapshotReader struct {
+	r       *bufio.Reader
+	version int
+	flags   uint16
+	keys    []*EncryptionKey
+	created time.Time
+	// payload is the rest of the current section
+	payload []byte
+	buf     bytes.Buffer
+	count   uint32
+	total   uint32
+	done    bool
+	// legacy holds the entries of the legacy backup
+	legacy []SnapshotEntry
+}
+
+// NewSnapshotReader reads the header of the snapshot file from r.
+// The encrypted snapshot is decrypted with the key it was encrypted with
+// among the given ones.
+func NewSnapshotReader(r io.Reader, keys ...*EncryptionKey) (*SnapshotReader, error) {
+	reader := bufio.NewReader(r)
+
+	magic, err := reader.Peek(len(snapshotMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(magic) != snapshotMagic {
+		return newLegacyReader(reader)
+	}
+
+	header := make([]byte, snapshotHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, errSnapshotTruncated
+	}
+	if crc32.Checksum(header[:20], castagnoli) != binary.LittleEndian.Uint32(header[20:]) {
+		return nil, errSnapshotChecksum
+	}
+	version := binary.LittleEndian.Uint16(header[8:])
+	flags := binary.LittleEndian.Uint16(header[10:])
+	if version != snapshotVersion || flags&^knownFlags != 0 {
+		return nil, errSnapshotVersion
+	}
+
+	return &SnapshotReader{
+		r:       reader,
+		version: int(version),
+		flags:   flags,
+		keys:    keys,
+		created: time.Unix(int64(binary.LittleEndian.Uint64(header[12:])), 0),
+	}, nil
+}
+
+// newLegacyReader decodes the gob encoded backup, either the map of the
+// items or the stream of the entries. The whole backup is decoded at once.
+func newLegacyReader(r io.Reader) (*SnapshotReader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []SnapshotEntry
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	for {
+		var entry SnapshotEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			entries = nil
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	if entries == nil {
+		values := make(map[string]*Item)
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+			return nil, errSnapshotCorrupted
+		}
+		for key, item := range values {
+			entries = append(entries, SnapshotEntry{Key: key, Value: item.Value})
+		}
+	}
+
+	return &SnapshotReader{legacy: entries, done: true}, nil
+}
+
+// Version returns the version of the snapshot file format, 0 for the legacy backup.
+func (sr *SnapshotReader) Version() int {
+	return sr.version
+}
+
+// Compressed reports whether the entries are compressed.
+func (sr *SnapshotReader) Compressed() bool {
+	return sr.flags&flagCompressed != 0
+}
+
+// Encrypted reports whether the entries are encrypted.
+func (sr *SnapshotReader) Encrypted() bool {
+	return sr.flags&flagEncrypted != 0
+}
+
+// Created returns the time the snapshot was started, zero for the legacy backup.
+func (sr *SnapshotReader) Created() time.Time {
+	return sr.created
+}
+
+// Next returns the next entry. It returns io.EOF after the last entry,
+// if the whole file is valid.
+func (sr *SnapshotReader) Next() (SnapshotEntry, error) {
+	if sr.legacy != nil || sr.done {
+		if len(sr.legacy) == 0 {
+			return SnapshotEntry{}, io.EOF
+		}
+		entry := sr.legacy[0]
+		sr.legacy = sr.legacy[1:]
+		return entry, nil
+	}
+
+	for sr.count == 0 {
+		if err := sr.readSection(); err != nil {
+			return SnapshotEntry{}, err
+		}
+		if sr.done {
+			return SnapshotEntry{}, io.EOF
+		}
+	}
+
+	entry, err := sr.decodeEntry()
+	if err != nil {
+		return SnapshotEntry{}, err
+	}
+	sr.count--
+	if sr.count == 0 && len(sr.payload) != 0 {
+		return SnapshotEntry{}, errSnapshotCorrupted
+	}
+	return entry, nil
+}
+
+// readSection reads the next section and verifies its checksum.
+func (sr *SnapshotReader) readSection() error {
+	header := make([]byte, sectionHeaderSize)
+	if _, err := io.ReadFull(sr.r, header); err != nil {
+		return errSnapshotTruncated
+	}
+
+	kind := header[0]
+	count := binary.LittleEndian.Uint32(header[1:])
+	length := binary.LittleEndian.Uint32(header[5:])
+	if length > maxSnapshotSection {
+		return errSnapshotCorrupted
+	}
+
+	// the payload is read gradually, so the corrupted length
+	// doesn't allocate more memory than the file has
+	sr.buf.Reset()
+	if _, err := io.CopyN(&sr.buf, sr.r, int64(length)); err != nil {
+		return errSnapshotTruncated
+	}
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(sr.r, trailer); err != nil {
+		return errSnapshotTruncated
+	}
+
+	payload := sr.buf.Bytes()
+	crc := crc32.Update(crc32.Checksum(header, castagnoli), castagnoli, payload)
+	if crc != binary.LittleEndian.Uint32(trailer) {
+		return errSnapshotChecksum
+	}
+
+	switch {
+	case kind == sectionEntries && count > 0:
+		if sr.flags != 0 {
+			var err error
+			if payload, err = decodePayload(payload, sr.flags, sr.keys, header[:5]); err != nil {
+				return err
+			}
+		}
+		sr.payload = payload
+		sr.count = count
+		sr.total += count
+	case kind == sectionEnd && length == 0:
+		if count != sr.total {
+			return errSnapshotCorrupted
+		}
+		if _, err := sr.r.Peek(1); err != io.EOF {
+			return errSnapshotCorrupted
+		}
+		sr.done = true
+	default:
+		return errSnapshotCorrupted
+	}
+	return nil
+}
+
+func (sr *SnapshotReader) decodeEntry() (SnapshotEntry, error) {
+	var entry SnapshotEntry
+	var err error
+
+	if entry.Key, err = sr.string(); err != nil {
+		return entry, err
+	}
+	if len(sr.payload) == 0 {
+		return entry, errSnapshotCorrupted
+	}
+	kind := sr.payload[0]
+	sr.payload = sr.payload[1:]
+
+	if entry.Expire, err = sr.varint(); err != nil {
+		return entry, err
+	}
+	if entry.Access, err = sr.varint(); err != nil {
+		return entry, err
+	}
+	lfu, err := sr.uvarint()
+	if err != nil || lfu > 1<<32-1 {
+		return entry, errSnapshotCorrupted
+	}
+	entry.LFU = uint32(lfu)
+
+	switch kind {
+	case 's':
+		entry.Value, err = sr.string()
+	case 'l':
+		var n uint64
+		if n, err = sr.length(); err != nil {
+			return entry, err
+		}
+		list := make([]string, n)
+		for i := range list {
+			if list[i], err = sr.string(); err != nil {
+				return entry, err
+			}
+		}
+		entry.Value = list
+	case 'h':
+		var n uint64
+		if n, err = sr.length(); err != nil {
+			return entry, err
+		}
+		hash := make(map[string]string, n)
+		for i := uint64(0); i < n; i++ {
+			key, err := sr.string()
+			if err != nil {
+				return entry, err
+			}
+			if hash[key], err = sr.string(); err != nil {
+				return entry, err
+			}
+		}
+		entry.Value = hash
+	default:
+		err = errSnapshotCorrupted
+	}
+	return entry, err
+}
+
+func (sr *SnapshotReader) uvarint() (uint64, error) {
+	value, n := binary.Uvarint(sr.payload)
+	if n <= 0 {
+		return 0, errSnapshotCorrupted
+	}
+	sr.payload = sr.payload[n:]
+	return value, nil
+}
+
+func (sr *SnapshotReader) varint() (int64, error) {
+	value, n := binary.Varint(sr.payload)
+	if n <= 0 {
+		return 0, errSnapshotCorrupted
+	}
+	sr.payload = sr.payload[n:]
+	return value, nil
+}
+
+// length reads the length, which can't exceed the rest of the payload,
+// as every element takes at least one byte.
+func (sr *SnapshotReader) length() (uint64, error) {
+	n, err := sr.uvarint()
+	if err != nil || n > uint64(len(sr.payload)) {
+		return 0, errSnapshotCorrupted
+	}
+	return n, nil
+}
+
+func (sr *SnapshotReader) string() (string, error) {
+	n, err := sr.length()
+	if err != nil {
+		return "", err
+	}
+	value := string(sr.payload[:n])
+	sr.payload = sr.payload[n:]
+	return value, nil
+}
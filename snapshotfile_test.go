@@ -0,0 +1,178 @@
+package inmemory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSnapshot(t *testing.T, entries []SnapshotEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer, err := NewSnapshotWriter(&buf, time.Unix(1500000000, 0), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := writer.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readSnapshot(data []byte) ([]SnapshotEntry, error) {
+	reader, err := NewSnapshotReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []SnapshotEntry
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	entries := []SnapshotEntry{
+		{Key: "string", Value: "value", Expire: 1700000000},
+		{Key: "", Value: ""},
+		{Key: "list", Value: []string{"a", "", "c"}, Access: 42},
+		{Key: "hash", Value: map[string]string{"a": "1", "b": ""}, LFU: 1<<32 - 1},
+	}
+	// the entries take several sections
+	large := strings.Repeat("x", snapshotSectionSize/2)
+	for i := 0; i < 5; i++ {
+		entries = append(entries, SnapshotEntry{Key: "large" + strconv.Itoa(i), Value: large, Expire: -1})
+	}
+
+	data := testSnapshot(t, entries)
+	reader, _ := NewSnapshotReader(bytes.NewReader(data))
+	if reader.Version() != snapshotVersion || reader.Created() != time.Unix(1500000000, 0) {
+		t.Errorf("Unexpected header: version %d, created %v", reader.Version(), reader.Created())
+	}
+
+	read, err := readSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("Expected %d entries to be read back, got %d", len(entries), len(read))
+	}
+
+	writer, _ := NewSnapshotWriter(io.Discard, time.Now(), false, nil)
+	if err := writer.Write(SnapshotEntry{Key: "int", Value: 1}); err != errSnapshotValue {
+		t.Errorf("Expected %v, got %v", errSnapshotValue, err)
+	}
+}
+
+func TestSnapshotFileCorrupted(t *testing.T) {
+	data := testSnapshot(t, []SnapshotEntry{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: []string{"2"}},
+	})
+
+	corrupt := func(change func(data []byte) []byte) []byte {
+		return change(append([]byte(nil), data...))
+	}
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"header checksum", corrupt(func(d []byte) []byte { d[14]++; return d }), errSnapshotChecksum},
+		{"payload checksum", corrupt(func(d []byte) []byte { d[snapshotHeaderSize+sectionHeaderSize]++; return d }), errSnapshotChecksum},
+		{"version", testHeader(2, 0), errSnapshotVersion},
+		{"unknown flags", testHeader(snapshotVersion, 4), errSnapshotVersion},
+		{"truncated header", data[:10], errSnapshotTruncated},
+		{"truncated section", data[:len(data)-20], errSnapshotTruncated},
+		{"no end section", data[:len(data)-sectionHeaderSize-4], errSnapshotTruncated},
+		{"trailing data", corrupt(func(d []byte) []byte { return append(d, 0) }), errSnapshotCorrupted},
+		{"not a snapshot", []byte("not a snapshot file"), errSnapshotCorrupted},
+		{"empty", nil, errSnapshotCorrupted},
+	}
+	for _, c := range cases {
+		if _, err := readSnapshot(c.data); err != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, err)
+		}
+	}
+}
+
+// testHeader returns the valid header of the snapshot with the given version and flags.
+func testHeader(version, flags uint16) []byte {
+	var buf bytes.Buffer
+	NewSnapshotWriter(&buf, time.Now(), false, nil)
+	header := buf.Bytes()
+	binary.LittleEndian.PutUint16(header[8:], version)
+	binary.LittleEndian.PutUint16(header[10:], flags)
+	binary.LittleEndian.PutUint32(header[20:], crc32.Checksum(header[:20], castagnoli))
+	return header
+}
+
+func TestLegacyBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache_data20180101000000.gob")
+
+	file, _ := os.Create(path)
+	gob.NewEncoder(file).Encode(map[string]*Item{
+		"string": {Value: "value"},
+		"list":   {Value: []string{"a", "b"}},
+		"hash":   {Value: map[string]string{"a": "1"}},
+	})
+	file.Close()
+
+	// the legacy backups are found by their extension
+	dataStore, _ := NewWithOptions(WithWorkers(NoWorkers), WithBackups(dir, 1))
+	defer dataStore.Close()
+	if restored, err := dataStore.RestoreLatest(); err != nil || restored != path {
+		t.Fatalf("Expected %s restored, got %q, %v", path, restored, err)
+	}
+
+	if value, _ := dataStore.Get("string"); value != "value" {
+		t.Errorf("Expected restored string, got %q", value)
+	}
+	if list, _ := dataStore.List("list"); !reflect.DeepEqual(list, []string{"a", "b"}) {
+		t.Errorf("Expected list [a b], got %v", list)
+	}
+	if hash, _ := dataStore.Hash("hash"); !reflect.DeepEqual(hash, map[string]string{"a": "1"}) {
+		t.Errorf("Expected hash {a:1}, got %v", hash)
+	}
+}
+
+func TestTruncatedBackupNotRestored(t *testing.T) {
+	path := testBackup(t, t.TempDir(), "cache_data20000101000000.snap", "key")
+
+	// the entries are complete, only the end section is missing
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-sectionHeaderSize-4)
+
+	dataStore, _ := NewWithOptions(WithWorkers(NoWorkers))
+	defer dataStore.Close()
+	if err := dataStore.FromFile(path); err != errSnapshotTruncated {
+		t.Errorf("Expected %v, got %v", errSnapshotTruncated, err)
+	}
+	if size := dataStore.Size(); size != 0 {
+		t.Errorf("Expected nothing restored, got %d keys", size)
+	}
+}
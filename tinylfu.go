@@ -0,0 +1,299 @@
+package inmemory
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+// W-TinyLFU keeps the keys in three LRU lists:
+//   - window, the small list all new keys are put to;
+//   - probation, the part of the main cache with the keys used only once
+//     since getting there;
+//   - protected, the part of the main cache with the keys used again.
+//
+// When the window grows over its share of keys, its least recently used key
+// is the candidate to enter the main cache. While the main cache is full,
+// it's admitted only if its frequency, estimated by the count-min sketch,
+// is higher than the frequency of the main cache victim. The rejected
+// candidate becomes the next victim itself. So the one-off keys, e.g. from
+// the scans, can't flush the hot keys from the cache.
+const (
+	// window takes 1% of all keys
+	tinyWindowPercent = 1
+	// protected takes 80% of the main cache
+	tinyProtectedPercent = 80
+)
+
+// regions of the W-TinyLFU cache
+const (
+	tinyWindow = iota
+	tinyProbation
+	tinyProtected
+)
+
+// tinyEntry is the value of the list element for the key in W-TinyLFU.
+// It references the item to keep its link to the element when the entry
+// is moved between the lists.
+type tinyEntry struct {
+	key    string
+	item   *Item
+	region int
+}
+
+// tinyLFU is the eviction policy with the TinyLFU admission filter
+// in front of the segmented LRU cache.
+type tinyLFU struct {
+	sketch    *sketch
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	// capacity is the number of keys left after the last eviction, as the
+	// memory limit defines the size of the cache. It's 0 until the first
+	// eviction, so all the keys are admitted while there's free memory.
+	capacity int
+}
+
+func newTinyLFU() EvictionPolicy {
+	return &tinyLFU{
+		sketch:    newSketch(sketchMinWidth),
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+	}
+}
+
+func (p *tinyLFU) len() int {
+	return p.window.Len() + p.probation.Len() + p.protected.Len()
+}
+
+func (p *tinyLFU) region(region int) *list.List {
+	switch region {
+	case tinyWindow:
+		return p.window
+	case tinyProbation:
+		return p.probation
+	default:
+		return p.protected
+	}
+}
+
+// move puts the entry to the front of the region's list.
+func (p *tinyLFU) move(entry *tinyEntry, region int) {
+	p.region(entry.region).Remove(entry.item.el)
+	entry.region = region
+	entry.item.el = p.region(region).PushFront(entry)
+}
+
+// windowMax returns the share of the window in the cache.
+func (p *tinyLFU) windowMax() int {
+	size := p.capacity
+	if size == 0 {
+		size = p.len()
+	}
+	windowMax := size * tinyWindowPercent / 100
+	if windowMax < 1 {
+		windowMax = 1
+	}
+	return windowMax
+}
+
+// Add puts the new key to the window and passes the window keys over
+// its share to the admission.
+func (p *tinyLFU) Add(key string, item *Item) {
+	p.sketch.grow(p.len() + 1)
+	p.sketch.increment(key)
+	item.el = p.window.PushFront(&tinyEntry{key: key, item: item, region: tinyWindow})
+	p.admit()
+}
+
+// admit moves the least recently used window keys over its share to the
+// main cache. The candidates are admitted without competition while the
+// main cache has free space. Otherwise the candidate competes with the main
+// cache victim: the winner goes to the front of probation, the loser stays
+// at its back and is evicted first.
+func (p *tinyLFU) admit() {
+	windowMax := p.windowMax()
+	for p.window.Len() > windowMax {
+		candidate := p.window.Back().Value.(*tinyEntry)
+
+		if p.capacity == 0 || p.probation.Len()+p.protected.Len() < p.capacity-windowMax {
+			p.move(candidate, tinyProbation)
+			continue
+		}
+
+		victim := p.victim(nil, p.probation, p.protected)
+		if victim == nil || p.sketch.estimate(candidate.key) > p.sketch.estimate(victim.key) {
+			p.move(candidate, tinyProbation)
+			continue
+		}
+
+		p.window.Remove(candidate.item.el)
+		candidate.region = tinyProbation
+		candidate.item.el = p.probation.PushBack(candidate)
+	}
+}
+
+// Access records the key usage in the sketch and updates its recency.
+// The key used again in probation is promoted to the protected region.
+func (p *tinyLFU) Access(key string, item *Item) {
+	if item.el == nil {
+		return
+	}
+
+	p.sketch.increment(key)
+
+	entry := item.el.Value.(*tinyEntry)
+	if entry.region != tinyProbation {
+		p.region(entry.region).MoveToFront(item.el)
+		return
+	}
+
+	p.move(entry, tinyProtected)
+
+	// demote the least recently used protected keys if the region is overflown
+	main := p.probation.Len() + p.protected.Len()
+	for p.protected.Len() > main*tinyProtectedPercent/100 {
+		p.move(p.protected.Back().Value.(*tinyEntry), tinyProbation)
+	}
+}
+
+func (p *tinyLFU) Remove(key string, item *Item) {
+	if item.el == nil {
+		return
+	}
+	entry := item.el.Value.(*tinyEntry)
+	p.region(entry.region).Remove(item.el)
+	// break the link from the Item for element in the cache for safe removal
+	item.el = nil
+}
+
+// Evict returns up to n keys: the main cache victims in LRU order,
+// probation keys before the protected ones, and the window keys last.
+// The number of keys left becomes the capacity of the cache.
+func (p *tinyLFU) Evict(values map[string]*Item, n int) []string {
+	var keys []string
+
+	// victims already picked, they stay in the lists until removed from the data store
+	picked := make(map[*tinyEntry]struct{})
+	for len(keys) < n {
+		victim := p.victim(picked, p.probation, p.protected, p.window)
+		if victim == nil {
+			break
+		}
+		picked[victim] = struct{}{}
+		keys = append(keys, victim.key)
+	}
+
+	p.capacity = p.len() - len(keys)
+	return keys
+}
+
+// victim returns the least recently used entry from the lists, checked in
+// the given order. The already picked entries are skipped.
+func (p *tinyLFU) victim(picked map[*tinyEntry]struct{}, lists ...*list.List) *tinyEntry {
+	for _, l := range lists {
+		for el := l.Back(); el != nil; el = el.Prev() {
+			entry := el.Value.(*tinyEntry)
+			if _, ok := picked[entry]; !ok {
+				return entry
+			}
+		}
+	}
+	return nil
+}
+
+const (
+	// number of rows in the sketch, each row uses its own hash function
+	sketchDepth = 4
+	// min number of counters in each row
+	sketchMinWidth = 1024
+	// max value of the counter
+	sketchMaxCount = 15
+	// the counters are halved after width*sketchSamples increments
+	sketchSamples = 10
+)
+
+// sketch is the count-min sketch estimating the frequency of the keys.
+// The counters are periodically halved, so the estimation reflects
+// the recent popularity of the keys.
+type sketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// newSketch creates sketch with width rounded up to the power of two.
+func newSketch(width int) *sketch {
+	size := sketchMinWidth
+	for size < width {
+		size <<= 1
+	}
+
+	s := &sketch{
+		mask:    uint64(size - 1),
+		resetAt: size * sketchSamples,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+	return s
+}
+
+// grow widens the sketch if it's too narrow for the number of keys.
+// The collected frequencies are dropped in this case.
+func (s *sketch) grow(keys int) {
+	if uint64(keys) > s.mask+1 {
+		*s = *newSketch(keys * 2)
+	}
+}
+
+// indexes returns position of the key counter in each row.
+// Positions are derived from the single hash, using the double hashing.
+func (s *sketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	h1, h2 := sum, sum>>32|sum<<32
+	var indexes [sketchDepth]uint64
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return indexes
+}
+
+func (s *sketch) increment(key string) {
+	for i, index := range s.indexes(key) {
+		if s.rows[i][index] < sketchMaxCount {
+			s.rows[i][index]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// estimate returns the lowest counter of the key, as it's the least
+// affected by the collisions.
+func (s *sketch) estimate(key string) uint8 {
+	min := uint8(sketchMaxCount)
+	for i, index := range s.indexes(key) {
+		if s.rows[i][index] < min {
+			min = s.rows[i][index]
+		}
+	}
+	return min
+}
+
+// reset halves all the counters to age the frequencies.
+func (s *sketch) reset() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] >>= 1
+		}
+	}
+	s.additions /= 2
+}
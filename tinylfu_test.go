@@ -0,0 +1,200 @@
+package inmemory
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	s := newSketch(sketchMinWidth)
+
+	for i := 0; i < 5; i++ {
+		s.increment("hot")
+	}
+	s.increment("cold")
+
+	if hot, cold := s.estimate("hot"), s.estimate("cold"); hot < 5 || cold < 1 || hot <= cold {
+		t.Errorf("Expected hot key to be more frequent, got hot: %d, cold: %d", hot, cold)
+	}
+
+	for i := 0; i < 2*sketchMaxCount; i++ {
+		s.increment("hot")
+	}
+	if hot := s.estimate("hot"); hot != sketchMaxCount {
+		t.Errorf("Expected counter to stop at %d, got: %d", sketchMaxCount, hot)
+	}
+
+	s.reset()
+	if hot := s.estimate("hot"); hot != sketchMaxCount/2 {
+		t.Errorf("Expected counter to be halved to %d, got: %d", sketchMaxCount/2, hot)
+	}
+
+	s.grow(4 * sketchMinWidth)
+	if width := s.mask + 1; width < 4*sketchMinWidth {
+		t.Errorf("Expected sketch to grow to %d counters, got: %d", 4*sketchMinWidth, width)
+	}
+}
+
+// admitAll moves all the window keys to the main cache of the policy
+func admitAll(policy *tinyLFU) {
+	for policy.window.Len() > 0 {
+		policy.move(policy.window.Back().Value.(*tinyEntry), tinyProbation)
+	}
+}
+
+func TestTinyLFUPromotion(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-tinylfu")
+	policy := client.ds.shards[0].policy.(*tinyLFU)
+
+	testData(client, 10)
+	admitAll(policy)
+
+	for i := 0; i < 9; i++ {
+		client.Exec("get", []string{"key" + strconv.Itoa(i)})
+	}
+
+	// protected region takes only 80% of the main cache
+	if policy.protected.Len() != 8 || policy.probation.Len() != 2 {
+		t.Errorf("Expected 8 protected and 2 probation keys, got: %d and %d",
+			policy.protected.Len(), policy.probation.Len())
+	}
+
+	// probation keys are evicted first, demoted key0 is put to the front of probation
+	keys := evict(client, 3)
+	expected := []string{"key9", "key0", "key1"}
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected victims: %v, got: %v", expected, keys)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Errorf("Expected victims: %v, got: %v", expected, keys)
+		}
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-tinylfu")
+	policy := client.ds.shards[0].policy.(*tinyLFU)
+
+	testData(client, 10)
+	admitAll(policy)
+	// the memory limit was reached with 10 keys
+	evict(client, 0)
+
+	// popular key waits in the window, until the next key pushes it
+	// to the admission, then the same happens to the one-off key
+	client.Exec("set", []string{"popular", "value"})
+	for i := 0; i < 3; i++ {
+		client.Exec("get", []string{"popular"})
+	}
+	client.Exec("set", []string{"oneoff", "value"})
+	client.Exec("set", []string{"newest", "value"})
+
+	keys := evict(client, 2)
+	if len(keys) != 2 || keys[0] != "oneoff" || keys[1] != "key0" {
+		t.Errorf("Expected victims: [oneoff key0], got: %v", keys)
+	}
+
+	entry := client.ds.shards[0].values["popular"].el.Value.(*tinyEntry)
+	if entry.region != tinyProbation {
+		t.Errorf("Expected popular key to be admitted to probation, got region: %d", entry.region)
+	}
+}
+
+func TestTinyLFUScanResistance(t *testing.T) {
+	client := setupPolicyClient(t, "allkeys-tinylfu")
+	policy := client.ds.shards[0].policy.(*tinyLFU)
+
+	testData(client, 100)
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 3; j++ {
+			client.Exec("get", []string{"key" + strconv.Itoa(i)})
+		}
+	}
+	// the memory limit was reached with 100 keys
+	evict(client, 0)
+
+	// the scan adds many more one-hit keys than memoryd evicts at once
+	for i := 0; i < 500; i++ {
+		client.Exec("set", []string{"scan" + strconv.Itoa(i), "value"})
+	}
+	if size := policy.window.Len(); size > policy.windowMax() {
+		t.Errorf("Expected window to be bounded by %d keys, got: %d", policy.windowMax(), size)
+	}
+
+	for _, key := range evict(client, 20) {
+		if !strings.HasPrefix(key, "scan") {
+			t.Errorf("Expected one-hit keys to be evicted before the frequent ones, got: %s", key)
+		}
+	}
+}
+
+// hitRate runs the workload on the data store with given policy,
+// keeping the number of keys within the capacity.
+func hitRate(t testing.TB, policy string, capacity int, workload []string) float64 {
+	dataStore, err := NewWithOptions(WithEvictionPolicy(policy), WithShards(1), WithWorkers(NoWorkers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(dataStore)
+
+	for _, key := range workload {
+		if _, err := client.Exec("get", []string{key}); err != ErrNoItem {
+			continue
+		}
+
+		client.Exec("set", []string{key, key})
+
+		size := len(dataStore.shards[0].values)
+		if size > capacity {
+			client.Exec("remove_batch", evict(client, size-capacity))
+		}
+	}
+
+	return dataStore.Stats().HitRate()
+}
+
+// zipfWorkload generates the keys with Zipf distribution,
+// interrupted by scans of the unique keys.
+func zipfWorkload(n int) []string {
+	r := rand.New(rand.NewSource(42))
+	zipf := rand.NewZipf(r, 1.1, 1, 10000)
+
+	workload := make([]string, 0, n)
+	for i := 0; len(workload) < n; i++ {
+		if i%1000 == 0 {
+			for j := 0; j < 200; j++ {
+				workload = append(workload, "scan"+strconv.Itoa(i)+"_"+strconv.Itoa(j))
+			}
+		}
+		workload = append(workload, "key"+strconv.FormatUint(zipf.Uint64(), 10))
+	}
+	return workload
+}
+
+func TestTinyLFUHitRate(t *testing.T) {
+	workload := zipfWorkload(20000)
+
+	lruRate := hitRate(t, "allkeys-lru", 100, workload)
+	tinyRate := hitRate(t, "allkeys-tinylfu", 100, workload)
+
+	if tinyRate <= lruRate {
+		t.Errorf("Expected TinyLFU hit rate to be higher than LRU, got: %.3f <= %.3f", tinyRate, lruRate)
+	}
+}
+
+func BenchmarkHitRate(b *testing.B) {
+	workload := zipfWorkload(20000)
+
+	for _, policy := range []string{"allkeys-lru", "allkeys-lfu", "allkeys-tinylfu"} {
+		b.Run(policy, func(b *testing.B) {
+			var rate float64
+			for i := 0; i < b.N; i++ {
+				rate = hitRate(b, policy, 100, workload)
+			}
+			b.ReportMetric(rate, "hits/op")
+		})
+	}
+}